@@ -9,9 +9,11 @@ import (
 )
 
 func (a *applicationDependencies) createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	author := a.contextGetUser(r)
+
 	var incomingData struct {
-		Content string `json:"content"`
-		Author  string `json:"author"`
+		Content string      `json:"content"`
+		Target  data.Target `json:"target"`
 	}
 
 	err := a.readJSON(w, r, &incomingData)
@@ -21,18 +23,34 @@ func (a *applicationDependencies) createCommentHandler(w http.ResponseWriter, r
 	}
 
 	comment := &data.Comment{
+		UserID:  author.ID,
 		Content: incomingData.Content,
-		Author:  incomingData.Author,
+		Author:  author.Name,
+		Target:  incomingData.Target,
 	}
 
 	v := validator.New()
 	data.ValidateComment(v, comment)
+	if !comment.Target.IsZero() {
+		data.ValidateTarget(v, comment.Target)
+	}
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = a.commentModel.Insert(comment)
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	status, ok := a.admitComment(w, r, viewer, comment.Target, false)
+	if !ok {
+		return
+	}
+	comment.Status = status
+
+	err = a.commentStore(r).Insert(r.Context(), comment)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
@@ -40,6 +58,7 @@ func (a *applicationDependencies) createCommentHandler(w http.ResponseWriter, r
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/comments/%d", comment.ID))
+	setCommentValidators(headers, comment)
 
 	dataResponse := envelope{"comment": comment}
 	err = a.writeJSON(w, http.StatusCreated, dataResponse, headers)
@@ -55,7 +74,14 @@ func (a *applicationDependencies) displayCommentHandler(w http.ResponseWriter, r
 		return
 	}
 
-	comment, err := a.commentModel.Get(id)
+	// Comments that are not approved do not exist for the public
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, viewer)
 	if err != nil {
 		switch {
 		case err == data.ErrRecordNotFound:
@@ -66,14 +92,37 @@ func (a *applicationDependencies) displayCommentHandler(w http.ResponseWriter, r
 		return
 	}
 
+	if !viewer.CanSee(comment) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.loadReactions(r, comment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	setCommentValidators(headers, comment)
+	// The reactions flag the caller's own, so the response depends on who asks
+	w.Header().Add("Vary", "Authorization")
+
+	if notModified(r, comment) {
+		for key, value := range headers {
+			w.Header()[key] = value
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
 	dataResponse := envelope{"comment": comment}
-	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	err = a.writeJSON(w, http.StatusOK, dataResponse, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
 }
 
-
 func (a *applicationDependencies) updateCommentHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := a.readIDParam(r)
 	if err != nil {
@@ -81,7 +130,13 @@ func (a *applicationDependencies) updateCommentHandler(w http.ResponseWriter, r
 		return
 	}
 
-	comment, err := a.commentModel.Get(id)
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, viewer)
 	if err != nil {
 		switch {
 		case err == data.ErrRecordNotFound:
@@ -92,10 +147,18 @@ func (a *applicationDependencies) updateCommentHandler(w http.ResponseWriter, r
 		return
 	}
 
+	if !viewer.CanModify(comment) {
+		a.notPermittedResponse(w, r)
+		return
+	}
+
+	if !a.checkIfMatch(w, r, comment) {
+		return
+	}
+
 	// Input can be partial
 	var incomingData struct {
 		Content *string `json:"content"`
-		Author  *string `json:"author"`
 	}
 
 	err = a.readJSON(w, r, &incomingData)
@@ -107,9 +170,6 @@ func (a *applicationDependencies) updateCommentHandler(w http.ResponseWriter, r
 	if incomingData.Content != nil {
 		comment.Content = *incomingData.Content
 	}
-	if incomingData.Author != nil {
-		comment.Author = *incomingData.Author
-	}
 
 	v := validator.New()
 	data.ValidateComment(v, comment)
@@ -118,9 +178,12 @@ func (a *applicationDependencies) updateCommentHandler(w http.ResponseWriter, r
 		return
 	}
 
-	err = a.commentModel.Update(comment)
+	err = a.commentStore(r).Update(r.Context(), comment)
 	if err != nil {
 		switch {
+		// The comment changed after the If-Match check passed
+		case err == data.ErrEditConflict && r.Header.Get("If-Match") != "":
+			a.preconditionFailedResponse(w, r)
 		case err == data.ErrEditConflict:
 			a.editConflictResponse(w, r)
 		default:
@@ -129,8 +192,20 @@ func (a *applicationDependencies) updateCommentHandler(w http.ResponseWriter, r
 		return
 	}
 
+	// The ETag covers the reactions, so it matches the one a GET would send
+	// only once they are loaded
+	err = a.loadReactions(r, comment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	setCommentValidators(headers, comment)
+	w.Header().Add("Vary", "Authorization")
+
 	dataResponse := envelope{"comment": comment}
-	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	err = a.writeJSON(w, http.StatusOK, dataResponse, headers)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 	}
@@ -143,7 +218,33 @@ func (a *applicationDependencies) deleteCommentHandler(w http.ResponseWriter, r
 		return
 	}
 
-	err = a.commentModel.Delete(id)
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, viewer)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !viewer.CanModify(comment) {
+		a.notPermittedResponse(w, r)
+		return
+	}
+
+	if !a.checkIfMatch(w, r, comment) {
+		return
+	}
+
+	err = a.commentStore(r).Delete(r.Context(), comment.ID)
 	if err != nil {
 		switch {
 		case err == data.ErrRecordNotFound:
@@ -160,23 +261,308 @@ func (a *applicationDependencies) deleteCommentHandler(w http.ResponseWriter, r
 	}
 }
 
+func (a *applicationDependencies) listDeletedCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	v := validator.New()
+	filters := data.Filters{
+		Page:         a.readInt(query, "page", 1, v),
+		PageSize:     a.readInt(query, "page_size", 10, v),
+		Sort:         query.Get("sort"),
+		SortSafelist: []string{"id", "deleted", "-id", "-deleted"},
+	}
+	if filters.Sort == "" {
+		filters.Sort = "-deleted"
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := a.commentStore(r).GetDeleted(r.Context(), filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	dataResponse := envelope{
+		"comments": comments,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) restoreCommentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.commentStore(r).Restore(r.Context(), id)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, data.Viewer{UserID: a.contextGetUser(r).ID, Moderator: true})
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	dataResponse := envelope{"comment": comment}
+	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) createReplyHandler(w http.ResponseWriter, r *http.Request) {
+	author := a.contextGetUser(r)
+
+	parentID, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	parent, err := a.commentStore(r).Get(r.Context(), parentID, viewer)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !viewer.CanSee(parent) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Content string `json:"content"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Replies are about whatever their parent is about
+	comment := &data.Comment{
+		ParentID: &parent.ID,
+		UserID:   author.ID,
+		Content:  incomingData.Content,
+		Author:   author.Name,
+		Target:   parent.Target,
+	}
+
+	v := validator.New()
+	data.ValidateComment(v, comment)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	status, ok := a.admitComment(w, r, viewer, comment.Target, true)
+	if !ok {
+		return
+	}
+	comment.Status = status
+
+	err = a.commentStore(r).Insert(r.Context(), comment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/comments/%d", comment.ID))
+	setCommentValidators(headers, comment)
+
+	dataResponse := envelope{"comment": comment}
+	err = a.writeJSON(w, http.StatusCreated, dataResponse, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayThreadHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	maxDepth := a.readInt(r.URL.Query(), "max_depth", a.config.thread.maxDepth, v)
+	v.Check(maxDepth >= 1, "max_depth", "must be greater than zero")
+	v.Check(maxDepth <= a.config.thread.maxDepth, "max_depth", fmt.Sprintf("must not be more than %d", a.config.thread.maxDepth))
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	thread, err := a.commentStore(r).GetThread(r.Context(), id, maxDepth, viewer)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !viewer.CanSee(thread) {
+		a.notFoundResponse(w, r)
+		return
+	}
+	hideReplies(viewer, thread)
+
+	dataResponse := envelope{"thread": thread}
+	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
 // for pagination
 func (a *applicationDependencies) listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	a.listComments(w, r, data.Target{})
+}
+
+// listComments responds with a page of the comments matching the query
+// string, limited to those on target unless it is zero
+func (a *applicationDependencies) listComments(w http.ResponseWriter, r *http.Request, target data.Target) {
 	query := r.URL.Query()
 
-	page := a.readInt(query, "page", 1)
-	pageSize := a.readInt(query, "page_size", 10)
-	sort := query.Get("sort")
-	if sort == "" {
-		sort = "id"
+	v := validator.New()
+	filters := data.Filters{
+		Page:         a.readInt(query, "page", 1, v),
+		PageSize:     a.readInt(query, "page_size", 10, v),
+		Sort:         query.Get("sort"),
+		SortSafelist: []string{"id", "author", "created", "relevance", "top", "hot", "-id", "-author", "-created"},
+	}
+	if filters.Sort == "" {
+		filters.Sort = "id"
+	}
+	data.ValidateFilters(v, filters)
+
+	search := data.CommentSearch{
+		Query:         query.Get("q"),
+		Author:        query.Get("author"),
+		AuthorPrefix:  query.Get("author_prefix"),
+		CreatedAfter:  a.readTime(query, "created_after", v),
+		CreatedBefore: a.readTime(query, "created_before", v),
+		Target:        target,
+	}
+	data.ValidateCommentSearch(v, search)
+	if filters.Sort == "relevance" {
+		v.Check(search.Query != "", "sort", "relevance sorting requires a q parameter")
+	}
+
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	search.Viewer = viewer
+
+	// A cursor parameter, even an empty one, switches to keyset pagination
+	if query.Has("cursor") {
+		v.Check(!query.Has("page"), "page", "must not be used together with cursor")
+		var after *data.Cursor
+		if token := query.Get("cursor"); token != "" {
+			cursor, err := data.DecodeCursor(token, a.config.cursor.secret)
+			if err != nil {
+				v.AddError("cursor", "must be a cursor returned by a previous request")
+			} else {
+				v.Check(cursor.Sort == filters.Sort, "cursor", "was issued for a different sort order")
+				v.Check(cursor.Search == search.Digest(), "cursor", "was issued for a different search")
+				after = cursor
+			}
+		}
+		if !v.IsEmpty() {
+			a.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+		a.listCommentsByCursor(w, r, search, filters, after)
+		return
+	}
+
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := a.commentStore(r).GetAll(r.Context(), search, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	err = a.loadReactions(r, comments...)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
 	}
 
-	comments, metadata, err := a.commentModel.GetAll(page, pageSize, sort)
+	dataResponse := envelope{
+		"comments": comments,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) listCommentsByCursor(w http.ResponseWriter, r *http.Request, search data.CommentSearch, filters data.Filters, after *data.Cursor) {
+	comments, next, err := a.commentStore(r).GetAllAfter(r.Context(), search, filters, after)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	err = a.loadReactions(r, comments...)
 	if err != nil {
 		a.serverErrorResponse(w, r, err)
 		return
 	}
 
+	metadata := data.CursorMetadata{PageSize: filters.PageSize}
+	if next != nil {
+		metadata.NextCursor = next.Encode(a.config.cursor.secret)
+	}
+	if !search.IsEmpty() {
+		metadata.Search = &search
+	}
+
 	dataResponse := envelope{
 		"comments": comments,
 		"metadata": metadata,
@@ -0,0 +1,925 @@
+// Filename: cmd/api/comments_test.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"victortillett.net/basic/internal/data"
+)
+
+type commentResponse struct {
+	Comment data.Comment `json:"comment"`
+}
+
+type commentListResponse struct {
+	Comments []data.Comment `json:"comments"`
+	Metadata struct {
+		CurrentPage  int    `json:"current_page"`
+		LastPage     int    `json:"last_page"`
+		TotalRecords int    `json:"total_records"`
+		NextCursor   string `json:"next_cursor"`
+	} `json:"metadata"`
+}
+
+func TestCreateComment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	reader := createTestUser(t, app, "Bob")
+
+	tests := []struct {
+		name       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"valid", writer, `{"content": "first!"}`, http.StatusCreated},
+		{"empty content", writer, `{"content": ""}`, http.StatusUnprocessableEntity},
+		{"content too long", writer, fmt.Sprintf(`{"content": %q}`, strings.Repeat("a", 101)), http.StatusUnprocessableEntity},
+		{"anonymous", "", `{"content": "hello"}`, http.StatusUnauthorized},
+		{"without permission", reader, `{"content": "hello"}`, http.StatusForbidden},
+		{"invalid token", strings.Repeat("A", 26), `{"content": "hello"}`, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/comments", tt.token, tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusCreated {
+				return
+			}
+
+			var got commentResponse
+			res.decode(t, &got)
+			if got.Comment.Content != "first!" || got.Comment.Author != "Alice" || got.Comment.Version != 1 {
+				t.Errorf("unexpected comment %+v", got.Comment)
+			}
+			if want := fmt.Sprintf("/v1/comments/%d", got.Comment.ID); res.header.Get("Location") != want {
+				t.Errorf("got Location %q, want %q", res.header.Get("Location"), want)
+			}
+			if res.header.Get("ETag") != `"1"` {
+				t.Errorf("got ETag %q, want %q", res.header.Get("ETag"), `"1"`)
+			}
+		})
+	}
+}
+
+func TestDisplayComment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	comment := ts.createTestComment(t, writer, "hello")
+	path := fmt.Sprintf("/v1/comments/%d", comment.ID)
+
+	tests := []struct {
+		name       string
+		path       string
+		headers    []string
+		wantStatus int
+	}{
+		{"existing", path, nil, http.StatusOK},
+		{"missing", "/v1/comments/999", nil, http.StatusNotFound},
+		{"non-numeric id", "/v1/comments/abc", nil, http.StatusNotFound},
+		{"zero id", "/v1/comments/0", nil, http.StatusNotFound},
+		{"matching If-None-Match", path, []string{"If-None-Match", `"1"`}, http.StatusNotModified},
+		{"stale If-None-Match", path, []string{"If-None-Match", `"7"`}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodGet, tt.path, "", "", tt.headers...)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var got commentResponse
+			res.decode(t, &got)
+			if got.Comment.ID != comment.ID || got.Comment.Content != "hello" {
+				t.Errorf("unexpected comment %+v", got.Comment)
+			}
+		})
+	}
+}
+
+func TestUpdateComment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	owner := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	other := createTestUser(t, app, "Bob", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	comment := ts.createTestComment(t, owner, "original")
+	path := fmt.Sprintf("/v1/comments/%d", comment.ID)
+
+	// Each case runs against the comment as the previous ones left it
+	tests := []struct {
+		name        string
+		token       string
+		path        string
+		body        string
+		headers     []string
+		wantStatus  int
+		wantVersion int32
+	}{
+		{"by owner", owner, path, `{"content": "edited"}`, nil, http.StatusOK, 2},
+		{"by moderator", moderator, path, `{"content": "moderated"}`, nil, http.StatusOK, 3},
+		{"by another user", other, path, `{"content": "hijacked"}`, nil, http.StatusForbidden, 0},
+		{"empty patch", owner, path, `{}`, nil, http.StatusOK, 4},
+		{"invalid content", owner, path, `{"content": ""}`, nil, http.StatusUnprocessableEntity, 0},
+		{"matching If-Match", owner, path, `{"content": "conditional"}`, []string{"If-Match", `"4"`}, http.StatusOK, 5},
+		{"stale If-Match", owner, path, `{"content": "stale"}`, []string{"If-Match", `"4"`}, http.StatusPreconditionFailed, 0},
+		{"missing comment", owner, "/v1/comments/999", `{"content": "nobody"}`, nil, http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPatch, tt.path, tt.token, tt.body, tt.headers...)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var got commentResponse
+			res.decode(t, &got)
+			if got.Comment.Version != tt.wantVersion {
+				t.Errorf("got version %d, want %d", got.Comment.Version, tt.wantVersion)
+			}
+			if got.Comment.Author != "Alice" {
+				t.Errorf("got author %q, want the original author", got.Comment.Author)
+			}
+		})
+	}
+}
+
+// conflictingCommentStore simulates another client saving a comment between
+// the handler reading it and writing it back
+type conflictingCommentStore struct {
+	data.CommentStore
+}
+
+func (s conflictingCommentStore) Update(ctx context.Context, comment *data.Comment) error {
+	concurrent := *comment
+	concurrent.Content = "saved by someone else"
+	err := s.CommentStore.Update(ctx, &concurrent)
+	if err != nil {
+		return err
+	}
+	return s.CommentStore.Update(ctx, comment)
+}
+
+func (s conflictingCommentStore) WithLogger(logger *slog.Logger) data.CommentStore {
+	return conflictingCommentStore{s.CommentStore.WithLogger(logger)}
+}
+
+// blockingCommentStore holds Get until its context ends, like a slow query
+type blockingCommentStore struct {
+	data.CommentStore
+	cancelled chan error
+}
+
+func (s blockingCommentStore) Get(ctx context.Context, id int64, viewer data.Viewer) (*data.Comment, error) {
+	<-ctx.Done()
+	s.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func (s blockingCommentStore) WithLogger(logger *slog.Logger) data.CommentStore {
+	return s
+}
+
+func TestCancelledRequestCancelsQuery(t *testing.T) {
+	app := newTestApplication(t)
+	store := blockingCommentStore{CommentStore: app.commentModel, cancelled: make(chan error, 1)}
+	app.commentModel = store
+	ts := newTestServer(t, app.routes())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/v1/comments/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ts.Client().Do(req)
+	if err == nil {
+		t.Fatal("request completed, want it abandoned by the client")
+	}
+
+	select {
+	case err := <-store.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got query context error %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query was not cancelled with the request")
+	}
+}
+
+func TestUpdateCommentEditConflict(t *testing.T) {
+	app := newTestApplication(t)
+	app.commentModel = conflictingCommentStore{app.commentModel}
+	ts := newTestServer(t, app.routes())
+
+	owner := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+
+	tests := []struct {
+		name       string
+		headers    []string
+		wantStatus int
+	}{
+		{"without If-Match", nil, http.StatusConflict},
+		{"with If-Match", []string{"If-Match", `"1"`}, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comment := ts.createTestComment(t, owner, "original")
+			path := fmt.Sprintf("/v1/comments/%d", comment.ID)
+
+			res := ts.do(t, http.MethodPatch, path, owner, `{"content": "mine"}`, tt.headers...)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+
+			res = ts.do(t, http.MethodGet, path, "", "")
+			var got commentResponse
+			res.decode(t, &got)
+			if got.Comment.Content != "saved by someone else" {
+				t.Errorf("conflicting update overwrote the concurrent one: %q", got.Comment.Content)
+			}
+		})
+	}
+}
+
+func TestDeleteComment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	owner := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	other := createTestUser(t, app, "Bob", data.PermissionCommentsWrite)
+
+	comment := ts.createTestComment(t, owner, "short-lived")
+	path := fmt.Sprintf("/v1/comments/%d", comment.ID)
+
+	tests := []struct {
+		name       string
+		token      string
+		headers    []string
+		wantStatus int
+	}{
+		{"anonymous", "", nil, http.StatusUnauthorized},
+		{"by another user", other, nil, http.StatusForbidden},
+		{"stale If-Match", owner, []string{"If-Match", `"2"`}, http.StatusPreconditionFailed},
+		{"by owner", owner, []string{"If-Match", `"1"`}, http.StatusOK},
+		{"already deleted", owner, nil, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodDelete, path, tt.token, "", tt.headers...)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+
+	res := ts.do(t, http.MethodGet, path, "", "")
+	if res.status != http.StatusNotFound {
+		t.Errorf("deleted comment still displayed with status %d", res.status)
+	}
+}
+
+func TestListDeletedComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	ts.createTestComment(t, writer, "still here")
+	for _, content := range []string{"gone 1", "gone 2"} {
+		comment := ts.createTestComment(t, writer, content)
+		ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", comment.ID), writer, "")
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		query       string
+		wantStatus  int
+		wantContent []string
+	}{
+		{"by id", moderator, "sort=id", http.StatusOK, []string{"gone 1", "gone 2"}},
+		{"by descending id", moderator, "sort=-id", http.StatusOK, []string{"gone 2", "gone 1"}},
+		{"second page", moderator, "sort=id&page=2&page_size=1", http.StatusOK, []string{"gone 2"}},
+		{"invalid sort", moderator, "sort=author", http.StatusUnprocessableEntity, nil},
+		{"zero page", moderator, "page=0", http.StatusUnprocessableEntity, nil},
+		{"without permission", writer, "", http.StatusForbidden, nil},
+		{"anonymous", "", "", http.StatusUnauthorized, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodGet, "/v1/comments/trash?"+tt.query, tt.token, "")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var got commentListResponse
+			res.decode(t, &got)
+			content := []string{}
+			for _, comment := range got.Comments {
+				content = append(content, comment.Content)
+				if comment.DeletedAt == nil {
+					t.Errorf("got comment %d without deleted_at", comment.ID)
+				}
+			}
+			if strings.Join(content, "|") != strings.Join(tt.wantContent, "|") {
+				t.Errorf("got comments %q, want %q", content, tt.wantContent)
+			}
+			if got.Metadata.TotalRecords != 2 {
+				t.Errorf("got total_records %d, want 2", got.Metadata.TotalRecords)
+			}
+		})
+	}
+}
+
+func TestRestoreComment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	comment := ts.createTestComment(t, writer, "back from the dead")
+	ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", comment.ID), writer, "")
+	live := ts.createTestComment(t, writer, "never deleted")
+
+	path := fmt.Sprintf("/v1/comments/%d/restore", comment.ID)
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		wantStatus int
+	}{
+		{"anonymous", "", path, http.StatusUnauthorized},
+		{"by the author", writer, path, http.StatusForbidden},
+		{"missing", moderator, "/v1/comments/999/restore", http.StatusNotFound},
+		{"not deleted", moderator, fmt.Sprintf("/v1/comments/%d/restore", live.ID), http.StatusNotFound},
+		{"by a moderator", moderator, path, http.StatusOK},
+		{"already restored", moderator, path, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, tt.path, tt.token, "")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var got commentResponse
+			res.decode(t, &got)
+			if got.Comment.ID != comment.ID || got.Comment.Content != "back from the dead" || got.Comment.DeletedAt != nil {
+				t.Errorf("unexpected comment %+v", got.Comment)
+			}
+		})
+	}
+
+	res := ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d", comment.ID), "", "")
+	if res.status != http.StatusOK {
+		t.Errorf("got status %d displaying the restored comment, want %d", res.status, http.StatusOK)
+	}
+	res = ts.do(t, http.MethodGet, "/v1/comments/trash", moderator, "")
+	var trash commentListResponse
+	res.decode(t, &trash)
+	if len(trash.Comments) != 0 {
+		t.Errorf("got %d comments in the trash after restoring, want 0", len(trash.Comments))
+	}
+}
+
+func TestCommentRevisions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	other := createTestUser(t, app, "Bob", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	comment := ts.createTestComment(t, writer, "one two three")
+	path := fmt.Sprintf("/v1/comments/%d", comment.ID)
+
+	// Versions 1 and 2 are edits, 3 and 4 the delete and restore, 5 an edit
+	steps := []struct {
+		method string
+		path   string
+		token  string
+		body   string
+	}{
+		{"PATCH", path, writer, `{"content": "one 2 three"}`},
+		{http.MethodDelete, path, writer, ""},
+		{http.MethodPost, path + "/restore", moderator, ""},
+		{"PATCH", path, writer, "{\"content\": \"one 2 three\\nfour\"}"},
+	}
+	for _, step := range steps {
+		res := ts.do(t, step.method, step.path, step.token, step.body)
+		if res.status != http.StatusOK {
+			t.Fatalf("%s %s: got status %d: %s", step.method, step.path, res.status, res.body)
+		}
+	}
+
+	t.Run("list", func(t *testing.T) {
+		res := ts.do(t, http.MethodGet, path+"/revisions", writer, "")
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.status, res.body)
+		}
+
+		var got struct {
+			Revisions []data.Revision `json:"revisions"`
+		}
+		res.decode(t, &got)
+		var versions []string
+		for _, revision := range got.Revisions {
+			versions = append(versions, fmt.Sprintf("%d:%s", revision.Version, revision.Content))
+		}
+		want := "1:one two three|4:one 2 three|5:one 2 three\nfour"
+		if strings.Join(versions, "|") != want {
+			t.Errorf("got revisions %q, want %q", strings.Join(versions, "|"), want)
+		}
+		if n := len(got.Revisions); n > 0 && got.Revisions[n-1].ReplacedAt != nil {
+			t.Error("got replaced_at on the current version")
+		}
+	})
+
+	t.Run("access", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			token      string
+			path       string
+			wantStatus int
+		}{
+			{"author", writer, path + "/revisions", http.StatusOK},
+			{"moderator", moderator, path + "/revisions", http.StatusOK},
+			{"another user", other, path + "/revisions", http.StatusForbidden},
+			{"anonymous", "", path + "/revisions", http.StatusUnauthorized},
+			{"missing comment", writer, "/v1/comments/999/revisions", http.StatusNotFound},
+			{"another user's version", other, path + "/revisions/1", http.StatusForbidden},
+			{"version zero", writer, path + "/revisions/0", http.StatusNotFound},
+			{"non-numeric version", writer, path + "/revisions/first", http.StatusNotFound},
+			{"future version", writer, path + "/revisions/6", http.StatusNotFound},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res := ts.do(t, http.MethodGet, tt.path, tt.token, "")
+				if res.status != tt.wantStatus {
+					t.Errorf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+				}
+			})
+		}
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		// Versions 2 and 3 only survive in revision 4, which has their content
+		for version, want := range map[int]string{1: "one two three", 2: "one 2 three", 3: "one 2 three", 4: "one 2 three", 5: "one 2 three\nfour"} {
+			res := ts.do(t, http.MethodGet, fmt.Sprintf("%s/revisions/%d", path, version), writer, "")
+			if res.status != http.StatusOK {
+				t.Fatalf("version %d: got status %d: %s", version, res.status, res.body)
+			}
+			var got struct {
+				Revision data.Revision `json:"revision"`
+			}
+			res.decode(t, &got)
+			if int(got.Revision.Version) != version || got.Revision.Content != want {
+				t.Errorf("got version %d %q, want %d %q", got.Revision.Version, got.Revision.Content, version, want)
+			}
+		}
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		type diffResponse struct {
+			Diff struct {
+				From int    `json:"from"`
+				To   int    `json:"to"`
+				Mode string `json:"mode"`
+				Ops  []struct {
+					Op   string `json:"op"`
+					Text string `json:"text"`
+				} `json:"ops"`
+			} `json:"diff"`
+		}
+
+		tests := []struct {
+			name       string
+			query      string
+			wantStatus int
+			wantOps    string
+		}{
+			{"words", "diff_from=1", http.StatusOK, "equal:one |delete:two|insert:2|equal: three|insert:\nfour"},
+			{"lines", "diff_from=1&diff_mode=line", http.StatusOK, "delete:one two three|insert:one 2 three\nfour"},
+			{"from a gap", "diff_from=3", http.StatusOK, "equal:one 2 three|insert:\nfour"},
+			{"unknown mode", "diff_from=1&diff_mode=char", http.StatusUnprocessableEntity, ""},
+			{"negative version", "diff_from=-1", http.StatusUnprocessableEntity, ""},
+			{"non-numeric version", "diff_from=first", http.StatusUnprocessableEntity, ""},
+			{"missing version", "diff_from=9", http.StatusUnprocessableEntity, ""},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				res := ts.do(t, http.MethodGet, path+"/revisions/5?"+tt.query, writer, "")
+				if res.status != tt.wantStatus {
+					t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+				}
+				if res.status != http.StatusOK {
+					return
+				}
+
+				var got diffResponse
+				res.decode(t, &got)
+				var ops []string
+				for _, op := range got.Diff.Ops {
+					ops = append(ops, op.Op+":"+op.Text)
+				}
+				if strings.Join(ops, "|") != tt.wantOps {
+					t.Errorf("got ops %q, want %q", strings.Join(ops, "|"), tt.wantOps)
+				}
+				if got.Diff.To != 5 {
+					t.Errorf("got diff to %d, want 5", got.Diff.To)
+				}
+			})
+		}
+	})
+}
+
+func TestDeleteAndRestoreBumpVersion(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	comment := ts.createTestComment(t, writer, "here, gone, back")
+	path := fmt.Sprintf("/v1/comments/%d", comment.ID)
+
+	ts.do(t, http.MethodDelete, path, writer, "")
+	res := ts.do(t, http.MethodPost, path+"/restore", moderator, "")
+	var restored commentResponse
+	res.decode(t, &restored)
+	if restored.Comment.Version != 3 {
+		t.Errorf("got version %d after deleting and restoring, want 3", restored.Comment.Version)
+	}
+
+	// Copies fetched before the delete are stale
+	res = ts.do(t, http.MethodGet, path, "", "", "If-None-Match", `"1"`)
+	if res.status != http.StatusOK || res.header.Get("ETag") != `"3"` {
+		t.Errorf("got status %d and ETag %q, want %d and %q", res.status, res.header.Get("ETag"), http.StatusOK, `"3"`)
+	}
+	res = ts.do(t, "PATCH", path, writer, `{"content": "edited"}`, "If-Match", `"1"`)
+	if res.status != http.StatusPreconditionFailed {
+		t.Errorf("got status %d editing with the old version, want %d", res.status, http.StatusPreconditionFailed)
+	}
+}
+
+func TestCreateReply(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	replier := createTestUser(t, app, "Bob", data.PermissionCommentsWrite)
+	reader := createTestUser(t, app, "Dave")
+
+	parent := ts.createTestComment(t, writer, "start of a thread")
+	deleted := ts.createTestComment(t, writer, "gone")
+	ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", deleted.ID), writer, "")
+	path := fmt.Sprintf("/v1/comments/%d/replies", parent.ID)
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"valid", replier, path, `{"content": "a reply"}`, http.StatusCreated},
+		{"empty content", replier, path, `{"content": ""}`, http.StatusUnprocessableEntity},
+		{"malformed body", replier, path, `{"content": `, http.StatusBadRequest},
+		{"anonymous", "", path, `{"content": "a reply"}`, http.StatusUnauthorized},
+		{"without permission", reader, path, `{"content": "a reply"}`, http.StatusForbidden},
+		{"missing parent", replier, "/v1/comments/999/replies", `{"content": "a reply"}`, http.StatusNotFound},
+		{"deleted parent", replier, fmt.Sprintf("/v1/comments/%d/replies", deleted.ID), `{"content": "a reply"}`, http.StatusNotFound},
+		{"non-numeric id", replier, "/v1/comments/abc/replies", `{"content": "a reply"}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, tt.path, tt.token, tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusCreated {
+				return
+			}
+
+			var got commentResponse
+			res.decode(t, &got)
+			if got.Comment.ParentID == nil || *got.Comment.ParentID != parent.ID || got.Comment.Author != "Bob" || got.Comment.Content != "a reply" {
+				t.Errorf("unexpected reply %+v", got.Comment)
+			}
+			if want := fmt.Sprintf("/v1/comments/%d", got.Comment.ID); res.header.Get("Location") != want {
+				t.Errorf("got Location %q, want %q", res.header.Get("Location"), want)
+			}
+		})
+	}
+
+	var got commentResponse
+	ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d", parent.ID), "", "").decode(t, &got)
+	if got.Comment.ReplyCount != 1 {
+		t.Errorf("got reply_count %d on the parent, want 1", got.Comment.ReplyCount)
+	}
+}
+
+func TestDisplayThread(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+
+	reply := func(parentID int64, content string) int64 {
+		t.Helper()
+		res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/comments/%d/replies", parentID), writer, fmt.Sprintf(`{"content": %q}`, content))
+		if res.status != http.StatusCreated {
+			t.Fatalf("got status %d replying, want %d: %s", res.status, http.StatusCreated, res.body)
+		}
+		var got commentResponse
+		res.decode(t, &got)
+		return got.Comment.ID
+	}
+
+	// root <- first <- second <- third, and a deleted reply to root
+	root := ts.createTestComment(t, writer, "root")
+	first := reply(root.ID, "first")
+	second := reply(first, "second")
+	reply(second, "third")
+	removed := reply(root.ID, "removed")
+	ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", removed), writer, "")
+
+	deletedRoot := ts.createTestComment(t, writer, "deleted root")
+	ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", deletedRoot.ID), writer, "")
+
+	path := fmt.Sprintf("/v1/comments/%d/thread", root.ID)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantDepth  int
+	}{
+		{"whole thread", path, http.StatusOK, 3},
+		{"limited depth", path + "?max_depth=2", http.StatusOK, 2},
+		{"top level only", path + "?max_depth=1", http.StatusOK, 1},
+		{"zero depth", path + "?max_depth=0", http.StatusUnprocessableEntity, 0},
+		{"depth above the limit", path + "?max_depth=11", http.StatusUnprocessableEntity, 0},
+		{"non-numeric depth", path + "?max_depth=deep", http.StatusUnprocessableEntity, 0},
+		{"missing", "/v1/comments/999/thread", http.StatusNotFound, 0},
+		{"deleted", fmt.Sprintf("/v1/comments/%d/thread", deletedRoot.ID), http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodGet, tt.path, "", "")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Thread data.Comment `json:"thread"`
+			}
+			res.decode(t, &got)
+			if got.Thread.ID != root.ID || len(got.Thread.Replies) != 2 {
+				t.Fatalf("got thread %d with %d replies, want %d with 2", got.Thread.ID, len(got.Thread.Replies), root.ID)
+			}
+
+			// The deleted reply stays as a placeholder without its text
+			placeholder := got.Thread.Replies[1]
+			if placeholder.ID != removed || placeholder.Content != "" || placeholder.Author != "" || placeholder.DeletedAt == nil {
+				t.Errorf("unexpected placeholder %+v", placeholder)
+			}
+
+			depth := 0
+			for node := &got.Thread; len(node.Replies) > 0; node = node.Replies[0] {
+				depth++
+			}
+			if depth != tt.wantDepth {
+				t.Errorf("got depth %d, want %d", depth, tt.wantDepth)
+			}
+		})
+	}
+}
+
+func TestListComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob", data.PermissionCommentsWrite)
+
+	ts.createTestComment(t, alice, "the quick brown fox")
+	ts.createTestComment(t, bob, "a lazy dog")
+	ts.createTestComment(t, alice, "quick thinking")
+	deleted := ts.createTestComment(t, bob, "quick and gone")
+	ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", deleted.ID), bob, "")
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantContent []string
+		wantTotal   int
+	}{
+		{"default", "", http.StatusOK, []string{"the quick brown fox", "a lazy dog", "quick thinking"}, 3},
+		{"descending id", "sort=-id", http.StatusOK, []string{"quick thinking", "a lazy dog", "the quick brown fox"}, 3},
+		{"by author", "sort=-author", http.StatusOK, []string{"a lazy dog", "the quick brown fox", "quick thinking"}, 3},
+		{"second page", "page=2&page_size=2", http.StatusOK, []string{"quick thinking"}, 3},
+		{"past the last page", "page=3&page_size=2", http.StatusOK, []string{}, 0},
+		{"author filter", "author=Bob", http.StatusOK, []string{"a lazy dog"}, 1},
+		{"author prefix", "author_prefix=Al", http.StatusOK, []string{"the quick brown fox", "quick thinking"}, 2},
+		{"full text", "q=quick", http.StatusOK, []string{"the quick brown fox", "quick thinking"}, 2},
+		{"full text negation", "q=quick+-fox", http.StatusOK, []string{"quick thinking"}, 1},
+		{"invalid sort", "sort=content", http.StatusUnprocessableEntity, nil, 0},
+		{"relevance without q", "sort=relevance", http.StatusUnprocessableEntity, nil, 0},
+		{"zero page", "page=0", http.StatusUnprocessableEntity, nil, 0},
+		{"oversized page", "page_size=101", http.StatusUnprocessableEntity, nil, 0},
+		{"non-numeric page", "page=one", http.StatusUnprocessableEntity, nil, 0},
+		{"malformed time", "created_after=yesterday", http.StatusUnprocessableEntity, nil, 0},
+		{"page with cursor", "cursor=&page=1", http.StatusUnprocessableEntity, nil, 0},
+		{"forged cursor", "cursor=abc.def", http.StatusUnprocessableEntity, nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodGet, "/v1/comments?"+tt.query, "", "")
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var got commentListResponse
+			res.decode(t, &got)
+			content := []string{}
+			for _, comment := range got.Comments {
+				content = append(content, comment.Content)
+			}
+			if strings.Join(content, "|") != strings.Join(tt.wantContent, "|") {
+				t.Errorf("got comments %q, want %q", content, tt.wantContent)
+			}
+			if got.Metadata.TotalRecords != tt.wantTotal {
+				t.Errorf("got total_records %d, want %d", got.Metadata.TotalRecords, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestListCommentsByCursor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	for i := 1; i <= 5; i++ {
+		ts.createTestComment(t, writer, fmt.Sprintf("comment %d", i))
+	}
+
+	var content []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("cursor pagination did not terminate")
+		}
+
+		query := url.Values{"sort": {"-id"}, "page_size": {"2"}, "cursor": {cursor}}
+		res := ts.do(t, http.MethodGet, "/v1/comments?"+query.Encode(), "", "")
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.status, res.body)
+		}
+
+		var got commentListResponse
+		res.decode(t, &got)
+		for _, comment := range got.Comments {
+			content = append(content, comment.Content)
+		}
+		if got.Metadata.NextCursor == "" {
+			break
+		}
+		cursor = got.Metadata.NextCursor
+	}
+
+	want := "comment 5|comment 4|comment 3|comment 2|comment 1"
+	if strings.Join(content, "|") != want {
+		t.Errorf("got %q, want %q", strings.Join(content, "|"), want)
+	}
+
+	// A cursor only fits the sort order it was issued for
+	query := url.Values{"sort": {"id"}, "page_size": {"2"}, "cursor": {cursor}}
+	res := ts.do(t, http.MethodGet, "/v1/comments?"+query.Encode(), "", "")
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for a cursor with another sort, want %d", res.status, http.StatusUnprocessableEntity)
+	}
+
+	// or the search and filters
+	for _, query := range []url.Values{
+		{"q": {"comment"}},
+		{"author": {"Alice"}},
+		{"created_after": {"2000-01-01T00:00:00Z"}},
+	} {
+		query.Set("sort", "-id")
+		query.Set("cursor", cursor)
+		res := ts.do(t, http.MethodGet, "/v1/comments?"+query.Encode(), "", "")
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d for a cursor with %v, want %d", res.status, query, http.StatusUnprocessableEntity)
+		}
+	}
+}
+
+func TestReadJSONErrors(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+
+	tests := []struct {
+		name        string
+		body        string
+		wantMessage string
+	}{
+		{"syntax error", `{"content": "hi",}`, "badly-formed JSON (at character 18)"},
+		{"truncated body", `{"content": "hi"`, "badly-formed JSON"},
+		{"wrong field type", `{"content": 42}`, `incorrect JSON type for field "content"`},
+		{"wrong top-level type", `["hi"]`, "incorrect JSON type (at character 1)"},
+		{"empty body", ``, "body must not be empty"},
+		{"unknown key", `{"content": "hi", "author": "Mallory"}`, `body contains unknown key "author"`},
+		{"too large", fmt.Sprintf(`{"content": %q}`, strings.Repeat("a", 256_000)), "body must not be larger than 256000 bytes"},
+		{"two values", `{"content": "hi"}{"content": "again"}`, "body must only contain a single JSON value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/comments", writer, tt.body)
+			if res.status != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d: %s", res.status, http.StatusBadRequest, res.body)
+			}
+
+			var got struct {
+				Error string `json:"error"`
+			}
+			res.decode(t, &got)
+			if got.Error != tt.wantMessage {
+				t.Errorf("got error %q, want %q", got.Error, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestReadJSONPanicsOnInvalidDestination(t *testing.T) {
+	app := newTestApplication(t)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("readJSON did not panic for a non-pointer destination")
+		}
+	}()
+
+	var destination struct{}
+	req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.readJSON(nil, req, destination)
+}
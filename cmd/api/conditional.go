@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"victortillett.net/basic/internal/data"
+)
+
+// commentETag derives a strong entity tag from the comment version, which
+// changes on every edit. Votes, reactions, moderation and reports do not
+// change the version, so unless a comment is approved, visible and without
+// votes or reactions a digest of them is appended.
+func commentETag(comment *data.Comment) string {
+	if comment.Score == 0 && len(comment.Reactions) == 0 && comment.Status == data.CommentApproved && !comment.Hidden {
+		return fmt.Sprintf(`"%d"`, comment.Version)
+	}
+	digest := sha256.New()
+	fmt.Fprintf(digest, "%d\x00%s\x00%s\x00%t\x00", comment.Score, comment.Status, comment.ModerationReason, comment.Hidden)
+	for _, reaction := range comment.Reactions {
+		fmt.Fprintf(digest, "%s\x00%d\x00%t\x00", reaction.Emoji, reaction.Count, reaction.ReactedByMe)
+	}
+	return fmt.Sprintf(`"%d-%x"`, comment.Version, digest.Sum(nil)[:8])
+}
+
+// stateDigestRX matches an entity tag carrying a digest of the comment state
+// that does not change its version
+var stateDigestRX = regexp.MustCompile(`"(\d+)-[0-9a-f]+"`)
+
+// setCommentValidators adds the ETag and Last-Modified headers for comment
+func setCommentValidators(headers http.Header, comment *data.Comment) {
+	headers.Set("ETag", commentETag(comment))
+	headers.Set("Last-Modified", comment.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// etagListMatches reports whether a comma-separated If-Match or If-None-Match
+// value contains etag. Weak comparison ignores W/ prefixes; strong comparison
+// never matches a weak tag.
+func etagListMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified reports whether the client's cached copy of comment is still
+// current. If-Modified-Since is only consulted without If-None-Match.
+func notModified(r *http.Request, comment *data.Comment) bool {
+	if ifNoneMatch := strings.Join(r.Header.Values("If-None-Match"), ","); ifNoneMatch != "" {
+		return etagListMatches(ifNoneMatch, commentETag(comment), true)
+	}
+	// Votes, reactions and moderation do not change Last-Modified, so it
+	// cannot tell whether they have changed since
+	if comment.Score != 0 || comment.Reactions != nil || comment.Status != data.CommentApproved || comment.Hidden {
+		return false
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err == nil && !comment.UpdatedAt.Truncate(time.Second).After(since) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces the If-Match precondition on a request that changes
+// comment. It sends the error response itself and reports whether to carry on.
+func (a *applicationDependencies) checkIfMatch(w http.ResponseWriter, r *http.Request, comment *data.Comment) bool {
+	ifMatch := strings.Join(r.Header.Values("If-Match"), ",")
+	if ifMatch == "" {
+		if a.config.requireIfMatch {
+			a.preconditionRequiredResponse(w, r)
+			return false
+		}
+		return true
+	}
+	// Voting, reacting and moderation never conflict with an edit, so only
+	// the version must match
+	ifMatch = stateDigestRX.ReplaceAllString(ifMatch, `"$1"`)
+	if !etagListMatches(ifMatch, fmt.Sprintf(`"%d"`, comment.Version), false) {
+		a.preconditionFailedResponse(w, r)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+	"victortillett.net/basic/internal/validator"
+)
+
+// Every setting is a flag, and the same name is accepted in the config file
+// and, upper-cased with an API_ prefix, in the environment. Later sources
+// win: flag defaults, then the config file, then the environment, then the
+// command line. The environment variable or config key with a _FILE or
+// -file suffix names a file to read the value from instead, for secrets
+// mounted by an orchestrator.
+const configEnvPrefix = "API_"
+
+// Sources recorded for each setting and shown by -print-config
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// secretSettings are redacted by -print-config
+var secretSettings = []string{"db-dsn", "smtp-password", "metrics-password", "cursor-secret"}
+
+// dsnPasswordRX matches the password in a key=value connection string
+var dsnPasswordRX = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// configOptions are the flags that only control how configuration is loaded.
+// They are not read from the config file or printed.
+var configOptions = []string{"config", "print-config"}
+
+// configuration is the result of loading every configuration source
+type configuration struct {
+	settings    serverConfig
+	printConfig bool
+	flags       *flag.FlagSet
+	sources     map[string]string
+}
+
+// configErrors maps each invalid setting to what is wrong with it
+type configErrors map[string]string
+
+func (e configErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, key+": "+e[key])
+	}
+	return "invalid configuration: " + strings.Join(messages, "; ")
+}
+
+// loadConfig builds the configuration from the command line arguments, the
+// environment as seen through lookupEnv and the config file they name
+func loadConfig(args []string, lookupEnv func(string) (string, bool)) (*configuration, error) {
+	cfg := &configuration{
+		flags:   flag.NewFlagSet("api", flag.ContinueOnError),
+		sources: make(map[string]string),
+	}
+	fs := cfg.flags
+	settings := &cfg.settings
+
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "Read settings from this YAML or JSON file")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "Print the effective configuration, secrets redacted, and exit")
+
+	fs.IntVar(&settings.port, "port", 8081, "Server port")
+	fs.StringVar(&settings.environment, "env", "development", "Environment")
+	fs.StringVar(&settings.storage, "storage", "postgres", "Storage backend (postgres|memory)")
+	fs.DurationVar(&settings.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Grace period for in-flight requests on shutdown")
+	fs.DurationVar(&settings.shutdownDelay, "shutdown-delay", 0, "How long to keep serving with readiness failing before shutting down")
+	fs.StringVar(&settings.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	fs.IntVar(&settings.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL maximum open connections (0 is unlimited)")
+	fs.IntVar(&settings.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL maximum idle connections")
+	fs.DurationVar(&settings.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL maximum connection idle time (0 is unlimited)")
+	fs.DurationVar(&settings.db.maxLifetime, "db-max-lifetime", time.Hour, "PostgreSQL maximum connection lifetime (0 is unlimited)")
+	fs.DurationVar(&settings.db.queryTimeout, "db-query-timeout", 3*time.Second, "Cancel database queries that run longer than this")
+	fs.StringVar(&settings.migrate.action, "migrate", "", "Run a migration action and exit instead of serving (up|down|status|force)")
+	fs.IntVar(&settings.migrate.steps, "migrate-steps", 1, "Number of migrations to revert with -migrate=down")
+	fs.Int64Var(&settings.migrate.version, "migrate-version", -1, "Schema version to record with -migrate=force")
+	fs.BoolVar(&settings.requireIfMatch, "require-if-match", false, "Reject comment updates and deletes that do not send If-Match")
+	fs.IntVar(&settings.thread.maxDepth, "thread-max-depth", 10, "Maximum reply depth returned for a comment thread")
+
+	var trashRetentionDays int
+	fs.IntVar(&trashRetentionDays, "trash-retention-days", 30, "Days a deleted comment stays restorable before it is purged (0 disables purging)")
+	fs.DurationVar(&settings.trash.purgeInterval, "trash-purge-interval", time.Hour, "How often to purge expired deleted comments")
+
+	var cursorSecret string
+	fs.StringVar(&cursorSecret, "cursor-secret", "", "Key used to sign pagination cursors (random if empty)")
+
+	fs.StringVar(&settings.smtp.host, "smtp-host", "localhost", "SMTP host")
+	fs.IntVar(&settings.smtp.port, "smtp-port", 25, "SMTP port")
+	fs.StringVar(&settings.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&settings.smtp.password, "smtp-password", "", "SMTP password")
+	fs.StringVar(&settings.smtp.sender, "smtp-sender", "Comments <no-reply@comments.local>", "SMTP sender")
+
+	fs.BoolVar(&settings.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.Float64Var(&settings.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second per client")
+	fs.IntVar(&settings.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst per client")
+	fs.Float64Var(&settings.limiter.writeRPS, "limiter-write-rps", 0.2, "Rate limiter requests per second for creating and changing comments")
+	fs.IntVar(&settings.limiter.writeBurst, "limiter-write-burst", 5, "Rate limiter burst for creating and changing comments")
+	fs.Float64Var(&settings.limiter.authRPS, "limiter-auth-rps", 0.1, "Rate limiter requests per second for registration and token endpoints")
+	fs.IntVar(&settings.limiter.authBurst, "limiter-auth-burst", 5, "Rate limiter burst for registration and token endpoints")
+
+	// Pass a space-separated list of proxy addresses or CIDR ranges, e.g. "10.0.0.0/8"
+	var limiterTrustedProxies string
+	fs.StringVar(&limiterTrustedProxies, "limiter-trusted-proxies", "", "Proxies whose X-Forwarded-For header is trusted (space separated)")
+
+	fs.StringVar(&settings.metrics.addr, "metrics-addr", "", "Serve /metrics on this separate address, e.g. localhost:9090")
+	fs.StringVar(&settings.metrics.username, "metrics-username", "", "Serve /metrics on the main port behind basic auth with this username")
+	fs.StringVar(&settings.metrics.password, "metrics-password", "", "Password for -metrics-username")
+
+	var reactionsAllowed string
+	fs.StringVar(&reactionsAllowed, "reactions-allowed", "👍 👎 ❤️ 😂 🎉 😮 😢", "Emoji users may react to comments with (space separated)")
+	fs.BoolVar(&settings.moderation.premoderate, "moderation-premoderate", false, "Hold every new comment for approval by a moderator")
+	fs.IntVar(&settings.reports.hideThreshold, "reports-hide-threshold", 3, "Hide a comment once this many users have reported it (0 never hides)")
+
+	// Pass a space-separated list of origins, e.g. "http://localhost:8080"
+	var corsTrustedOrigins string
+	fs.StringVar(&corsTrustedOrigins, "cors-trusted-origins", "http://localhost:8080", "Trusted CORS origins (space separated)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.VisitAll(func(f *flag.Flag) { cfg.sources[f.Name] = sourceDefault })
+	fs.Visit(func(f *flag.Flag) { cfg.sources[f.Name] = sourceFlag })
+
+	// The config file can only be named by a flag or the environment
+	err = cfg.applyEnv(fs.Lookup("config"), lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+	var fileValues map[string]string
+	if configFile != "" {
+		fileValues, err = readConfigFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		value, found := fileValues[f.Name]
+		delete(fileValues, f.Name)
+		if cfg.sources[f.Name] == sourceFlag {
+			return
+		}
+		if found {
+			err := f.Value.Set(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("config file %s: invalid value %q for %s: %w", configFile, redact(f.Name, value), f.Name, err))
+			}
+			cfg.sources[f.Name] = sourceFile
+		}
+		err := cfg.applyEnv(f, lookupEnv)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	})
+	for key := range fileValues {
+		errs = append(errs, fmt.Errorf("config file %s: unknown setting %q", configFile, key))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	// Convert the settings that serverConfig stores in another form
+	if corsTrustedOrigins != "" {
+		settings.cors.trustedOrigins = strings.Fields(corsTrustedOrigins)
+	}
+	settings.reactions.allowed = strings.Fields(reactionsAllowed)
+	settings.limiter.trustedProxies, err = parseTrustedProxies(strings.Fields(limiterTrustedProxies))
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q for limiter-trusted-proxies: %w", limiterTrustedProxies, err)
+	}
+	settings.trash.retention = time.Duration(trashRetentionDays) * 24 * time.Hour
+	settings.cursor.secret = []byte(cursorSecret)
+
+	return cfg, nil
+}
+
+// envName returns the environment variable for a setting, e.g. API_DB_DSN
+func envName(setting string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// applyEnv sets f from its environment variable, or from the file named by
+// the variable with a _FILE suffix
+func (cfg *configuration) applyEnv(f *flag.Flag, lookupEnv func(string) (string, bool)) error {
+	name := envName(f.Name)
+	value, found := lookupEnv(name)
+	path, fromFile := lookupEnv(name + "_FILE")
+	switch {
+	case found && fromFile:
+		return fmt.Errorf("%s and %s_FILE are both set", name, name)
+	case fromFile:
+		var err error
+		value, err = readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %w", name, err)
+		}
+	case !found:
+		return nil
+	}
+
+	if cfg.sources[f.Name] == sourceFlag {
+		return nil
+	}
+	err := f.Value.Set(value)
+	if err != nil {
+		// The value may be a secret, so it is left out
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	cfg.sources[f.Name] = sourceEnv
+	return nil
+}
+
+// readSecretFile returns the contents of path without a trailing newline
+func readSecretFile(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(contents), "\r\n"), nil
+}
+
+// readConfigFile reads a YAML or JSON config file into setting names and
+// their values. Nested maps are joined into setting names, so
+//
+//	db:
+//	  dsn: postgres://...
+//
+// sets db-dsn, and lists are joined with spaces.
+func readConfigFile(path string) (map[string]string, error) {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil, fmt.Errorf("config file %s: must be .yaml, .yml or .json", path)
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, so one decoder reads both
+	var document map[string]any
+	err = yaml.Unmarshal(contents, &document)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	err = flattenConfig("", document, values)
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	// Resolve -file keys to the contents of the files they name
+	for key, value := range values {
+		setting, isFile := strings.CutSuffix(key, "-file")
+		if !isFile {
+			continue
+		}
+		if _, found := values[setting]; found {
+			return nil, fmt.Errorf("config file %s: %s and %s are both set", path, setting, key)
+		}
+		secret, err := readSecretFile(value)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+		delete(values, key)
+		values[setting] = secret
+	}
+
+	for _, option := range configOptions {
+		if _, found := values[option]; found {
+			return nil, fmt.Errorf("config file %s: %s cannot be set in a config file", path, option)
+		}
+	}
+	return values, nil
+}
+
+func flattenConfig(prefix string, document map[string]any, values map[string]string) error {
+	for key, value := range document {
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+		switch value := value.(type) {
+		case nil:
+		case map[string]any:
+			err := flattenConfig(key, value, values)
+			if err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			values[key] = strings.Join(items, " ")
+		case float64:
+			values[key] = strconv.FormatFloat(value, 'f', -1, 64)
+		case string, bool, int, int64, uint64:
+			values[key] = fmt.Sprint(value)
+		default:
+			return fmt.Errorf("unsupported value for %s", key)
+		}
+	}
+	return nil
+}
+
+// redact hides the secret in value if setting holds one
+func redact(setting, value string) string {
+	switch {
+	case value == "":
+		return value
+	case setting == "db-dsn":
+		u, err := url.Parse(value)
+		if err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
+		return dsnPasswordRX.ReplaceAllString(value, "${1}xxxxx")
+	case validator.PermittedValue(setting, secretSettings...):
+		return "xxxxx"
+	default:
+		return value
+	}
+}
+
+// print writes the effective configuration as YAML that can be used as a
+// config file, noting where each value came from
+func (cfg *configuration) print(w io.Writer) {
+	fmt.Fprintln(w, "# Effective configuration, secrets redacted")
+	cfg.flags.VisitAll(func(f *flag.Flag) {
+		if validator.PermittedValue(f.Name, configOptions...) {
+			return
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", f.Name, strconv.Quote(redact(f.Name, f.Value.String())), cfg.sources[f.Name])
+	})
+}
+
+// validate checks the whole configuration and reports every invalid setting
+func (cfg *configuration) validate() error {
+	settings := cfg.settings
+	v := validator.New()
+
+	v.Check(settings.port > 0 && settings.port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(settings.environment != "", "env", "must be provided")
+	v.Check(validator.PermittedValue(settings.storage, "postgres", "memory"), "storage", "must be postgres or memory")
+	v.Check(settings.shutdownTimeout > 0, "shutdown-timeout", "must be greater than zero")
+	v.Check(settings.shutdownDelay >= 0, "shutdown-delay", "must not be negative")
+	v.Check(settings.thread.maxDepth > 0, "thread-max-depth", "must be greater than zero")
+
+	if settings.storage == "postgres" {
+		v.Check(settings.db.dsn != "", "db-dsn", "must be provided for postgres storage (-db-dsn, API_DB_DSN or API_DB_DSN_FILE)")
+		v.Check(settings.db.maxOpenConns >= 0, "db-max-open-conns", "must not be negative")
+		v.Check(settings.db.maxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+		if settings.db.maxOpenConns > 0 {
+			v.Check(settings.db.maxIdleConns <= settings.db.maxOpenConns, "db-max-idle-conns", "must not be more than db-max-open-conns")
+		}
+		v.Check(settings.db.maxIdleTime >= 0, "db-max-idle-time", "must not be negative")
+		v.Check(settings.db.maxLifetime >= 0, "db-max-lifetime", "must not be negative")
+		v.Check(settings.db.queryTimeout > 0, "db-query-timeout", "must be greater than zero")
+	}
+
+	switch settings.migrate.action {
+	case "", "up", "status":
+	case "down":
+		v.Check(settings.migrate.steps > 0, "migrate-steps", "must be greater than zero")
+	case "force":
+		v.Check(settings.migrate.version >= 0, "migrate-version", "must be provided with -migrate=force")
+	default:
+		v.AddError("migrate", "must be up, down, status or force")
+	}
+	if settings.migrate.action != "" {
+		v.Check(settings.storage == "postgres", "migrate", "needs postgres storage")
+	}
+
+	v.Check(settings.trash.retention >= 0, "trash-retention-days", "must not be negative")
+	if settings.trash.retention > 0 {
+		v.Check(settings.trash.purgeInterval > 0, "trash-purge-interval", "must be greater than zero")
+	}
+
+	for _, emoji := range settings.reactions.allowed {
+		v.Check(utf8.RuneCountInString(emoji) <= 16, "reactions-allowed", fmt.Sprintf("%q is longer than 16 characters", emoji))
+	}
+
+	v.Check(settings.reports.hideThreshold >= 0, "reports-hide-threshold", "must not be negative")
+
+	v.Check(settings.smtp.port > 0 && settings.smtp.port <= 65535, "smtp-port", "must be between 1 and 65535")
+
+	if settings.limiter.enabled {
+		v.Check(settings.limiter.rps > 0, "limiter-rps", "must be greater than zero")
+		v.Check(settings.limiter.burst > 0, "limiter-burst", "must be greater than zero")
+		v.Check(settings.limiter.writeRPS > 0, "limiter-write-rps", "must be greater than zero")
+		v.Check(settings.limiter.writeBurst > 0, "limiter-write-burst", "must be greater than zero")
+		v.Check(settings.limiter.authRPS > 0, "limiter-auth-rps", "must be greater than zero")
+		v.Check(settings.limiter.authBurst > 0, "limiter-auth-burst", "must be greater than zero")
+	}
+
+	for _, origin := range settings.cors.trustedOrigins {
+		u, err := url.Parse(origin)
+		v.Check(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "", "cors-trusted-origins", fmt.Sprintf("%q is not an origin such as http://localhost:8080", origin))
+	}
+
+	if (settings.metrics.username == "") != (settings.metrics.password == "") {
+		v.AddError("metrics-password", "must be set together with metrics-username")
+	}
+
+	if !v.IsEmpty() {
+		return configErrors(v.Errors)
+	}
+	return nil
+}
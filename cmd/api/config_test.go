@@ -0,0 +1,172 @@
+// Filename: cmd/api/config_test.go
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testEnv returns a lookupEnv function reading from env
+func testEnv(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, found := env[key]
+		return value, found
+	}
+}
+
+// writeTestFile writes contents to name in a temporary directory
+func writeTestFile(t *testing.T, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(contents), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	configFile := writeTestFile(t, "api.yaml", `
+port: 4000
+env: staging
+smtp:
+  host: smtp.example
+  port: 2525
+limiter-rps: 3
+cors-trusted-origins:
+  - http://a.example
+  - http://b.example
+`)
+	env := map[string]string{
+		"API_SMTP_PORT": "587",
+		"API_PORT":      "5000",
+	}
+
+	cfg, err := loadConfig([]string{"-config", configFile, "-port", "6000"}, testEnv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := cfg.settings
+	tests := []struct {
+		setting    string
+		got, want  any
+		wantSource string
+	}{
+		{"port", settings.port, 6000, sourceFlag},
+		{"smtp-port", settings.smtp.port, 587, sourceEnv},
+		{"smtp-host", settings.smtp.host, "smtp.example", sourceFile},
+		{"env", settings.environment, "staging", sourceFile},
+		{"limiter-rps", settings.limiter.rps, 3.0, sourceFile},
+		{"limiter-burst", settings.limiter.burst, 4, sourceDefault},
+		{"cors-trusted-origins", strings.Join(settings.cors.trustedOrigins, ","), "http://a.example,http://b.example", sourceFile},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("got %s %v, want %v", tt.setting, tt.got, tt.want)
+		}
+		if cfg.sources[tt.setting] != tt.wantSource {
+			t.Errorf("got %s from %q, want %q", tt.setting, cfg.sources[tt.setting], tt.wantSource)
+		}
+	}
+}
+
+func TestLoadConfigSecretFiles(t *testing.T) {
+	dsnFile := writeTestFile(t, "dsn", "postgres://app:s3cret@db/comments\n")
+	passwordFile := writeTestFile(t, "password", "smtp-s3cret\n")
+	configFile := writeTestFile(t, "api.json", `{"smtp": {"password-file": "`+passwordFile+`"}}`)
+
+	cfg, err := loadConfig([]string{"-config", configFile}, testEnv(map[string]string{"API_DB_DSN_FILE": dsnFile}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.settings.db.dsn != "postgres://app:s3cret@db/comments" {
+		t.Errorf("got db-dsn %q from API_DB_DSN_FILE", cfg.settings.db.dsn)
+	}
+	if cfg.settings.smtp.password != "smtp-s3cret" {
+		t.Errorf("got smtp-password %q from smtp.password-file", cfg.settings.smtp.password)
+	}
+
+	var out bytes.Buffer
+	cfg.print(&out)
+	for _, secret := range []string{"s3cret", "smtp-s3cret"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("printed configuration contains %q:\n%s", secret, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), `db-dsn: "postgres://app:xxxxx@db/comments" # env`) {
+		t.Errorf("printed configuration does not show the redacted DSN:\n%s", out.String())
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	unknownKey := writeTestFile(t, "unknown.yaml", "prot: 4000\n")
+	badValue := writeTestFile(t, "bad.yaml", "port: lots\n")
+	toml := writeTestFile(t, "api.toml", "port = 4000\n")
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		wantErr string
+	}{
+		{"unknown file key", []string{"-config", unknownKey}, nil, `unknown setting "prot"`},
+		{"invalid file value", []string{"-config", badValue}, nil, `invalid value "lots" for port`},
+		{"unsupported file type", []string{"-config", toml}, nil, "must be .yaml, .yml or .json"},
+		{"invalid env value", nil, map[string]string{"API_LIMITER_ENABLED": "perhaps"}, "invalid value for API_LIMITER_ENABLED"},
+		{"value and file", nil, map[string]string{"API_DB_DSN": "a", "API_DB_DSN_FILE": "b"}, "API_DB_DSN and API_DB_DSN_FILE are both set"},
+		{"missing secret file", nil, map[string]string{"API_DB_DSN_FILE": "/does/not/exist"}, "API_DB_DSN_FILE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(tt.args, testEnv(tt.env))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	cfg, err := loadConfig([]string{
+		"-port", "70000",
+		"-limiter-write-rps", "0",
+		"-db-max-open-conns", "10",
+		"-db-max-idle-conns", "20",
+		"-db-query-timeout", "0s",
+		"-migrate", "force",
+		"-metrics-username", "prometheus",
+		"-cors-trusted-origins", "http://localhost:8080/",
+		"-reports-hide-threshold", "-1",
+	}, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cfg.validate()
+	var invalid configErrors
+	if !errors.As(err, &invalid) {
+		t.Fatalf("got error %v, want configErrors", err)
+	}
+	for _, setting := range []string{"port", "db-dsn", "db-max-idle-conns", "db-query-timeout", "limiter-write-rps", "migrate-version", "metrics-password", "cors-trusted-origins", "reports-hide-threshold"} {
+		if _, found := invalid[setting]; !found {
+			t.Errorf("%s was not reported invalid: %v", setting, err)
+		}
+	}
+
+	cfg, err = loadConfig([]string{"-storage", "memory"}, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.validate()
+	if err != nil {
+		t.Errorf("got error %v for the default memory configuration", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"victortillett.net/basic/internal/data"
+)
+
+type contextKey string
+
+const (
+	userContextKey    = contextKey("user")
+	requestContextKey = contextKey("request")
+)
+
+// requestInfo travels with a request through the middleware chain. It is
+// shared by pointer so that what inner middleware learns, like the user, is
+// seen by outer middleware such as the access log.
+type requestInfo struct {
+	id     string
+	logger *slog.Logger
+	user   *data.User
+	route  string
+}
+
+// contextSetRequestInfo returns a copy of the request with info stored in its context
+func (a *applicationDependencies) contextSetRequestInfo(r *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), requestContextKey, info)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestInfo retrieves the info stored by the requestID
+// middleware, or nil when the request did not pass through it
+func (a *applicationDependencies) contextGetRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(requestContextKey).(*requestInfo)
+	return info
+}
+
+// contextGetLogger returns the logger for the request, which tags every
+// record with the request ID and, once known, the user ID
+func (a *applicationDependencies) contextGetLogger(r *http.Request) *slog.Logger {
+	if info := a.contextGetRequestInfo(r); info != nil {
+		return info.logger
+	}
+	return a.logger
+}
+
+// contextSetUser returns a copy of the request with user stored in its context
+func (a *applicationDependencies) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	if info := a.contextGetRequestInfo(r); info != nil {
+		info.user = user
+		if !user.IsAnonymous() {
+			info.logger = info.logger.With("user_id", user.ID)
+		}
+	}
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser retrieves the user stored by the authenticate middleware.
+// It is only ever called after that middleware has run, so a missing value
+// is a programming error.
+func (a *applicationDependencies) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		panic("missing user value in request context")
+	}
+	return user
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -8,11 +10,15 @@ import (
 func (a *applicationDependencies) logError(r *http.Request, err error) {
 	method := r.Method
 	uri := r.URL.RequestURI()
-	a.logger.Error(err.Error(), "method", method, "uri", uri)
+	a.contextGetLogger(r).Error(err.Error(), "method", method, "uri", uri)
 }
 
 func (a *applicationDependencies) errorResponseJSON(w http.ResponseWriter, r *http.Request, status int, message any) {
 	errorData := envelope{"error": message}
+	// Lets a client quote the ID when reporting a problem
+	if info := a.contextGetRequestInfo(r); info != nil {
+		errorData["request_id"] = info.id
+	}
 	err := a.writeJSON(w, status, errorData, nil)
 	if err != nil {
 		a.logError(r, err)
@@ -21,7 +27,12 @@ func (a *applicationDependencies) errorResponseJSON(w http.ResponseWriter, r *ht
 }
 
 func (a *applicationDependencies) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	a.logError(r, err)
+	// A query cancelled because the client went away is not a server fault
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		a.contextGetLogger(r).Info("request cancelled by client", "method", r.Method, "uri", r.URL.RequestURI(), "error", err.Error())
+	} else {
+		a.logError(r, err)
+	}
 	message := "the server encountered a problem and could not process your request"
 	a.errorResponseJSON(w, r, http.StatusInternalServerError, message)
 }
@@ -41,6 +52,7 @@ func (a *applicationDependencies) badRequestResponse(w http.ResponseWriter, r *h
 }
 
 func (a *applicationDependencies) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
+	a.metrics.observeValidationFailures(errors)
 	a.errorResponseJSON(w, r, http.StatusUnprocessableEntity, errors)
 }
 
@@ -48,3 +60,49 @@ func (a *applicationDependencies) editConflictResponse(w http.ResponseWriter, r
 	message := "unable to update the record due to an edit conflict, please try again"
 	a.errorResponseJSON(w, r, http.StatusConflict, message)
 }
+
+func (a *applicationDependencies) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependencies) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	message := "invalid or missing authentication token"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependencies) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	a.errorResponseJSON(w, r, http.StatusUnauthorized, message)
+}
+
+func (a *applicationDependencies) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account must be activated to access this resource"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *applicationDependencies) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *applicationDependencies) targetClosedResponse(w http.ResponseWriter, r *http.Request, status string) {
+	message := fmt.Sprintf("comments on this target are %s", status)
+	a.errorResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *applicationDependencies) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
+	message := "rate limit exceeded"
+	a.errorResponseJSON(w, r, http.StatusTooManyRequests, message)
+}
+
+func (a *applicationDependencies) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since you last fetched it"
+	a.errorResponseJSON(w, r, http.StatusPreconditionFailed, message)
+}
+
+func (a *applicationDependencies) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this request must include an If-Match header"
+	a.errorResponseJSON(w, r, http.StatusPreconditionRequired, message)
+}
@@ -1,7 +1,16 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+	"time"
+)
 
+// readinessTimeout bounds each dependency check made by the readiness probe
+const readinessTimeout = 2 * time.Second
+
+// healthcheckHandler is the liveness probe. It only reports that the process
+// is serving requests, so a database outage never gets the server restarted.
 func (a *applicationDependencies) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
 		"status": "available",
@@ -15,3 +24,81 @@ func (a *applicationDependencies) healthcheckHandler(w http.ResponseWriter, r *h
 		a.serverErrorResponse(w, r, err)
 	}
 }
+
+// readinessHandler is the readiness probe. It answers 503 while the server
+// is shutting down, the database cannot be reached or the schema does not
+// match the embedded migrations.
+func (a *applicationDependencies) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	ready := !a.shuttingDown.Load()
+	checks := envelope{
+		"storage": a.config.storage,
+	}
+
+	if a.db != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		database := envelope{"status": "up"}
+		start := time.Now()
+		err := a.db.PingContext(ctx)
+		database["latency"] = time.Since(start).String()
+		if err != nil {
+			// The cause may include connection details, so it is only logged
+			a.contextGetLogger(r).Warn("readiness database ping failed", "error", err.Error())
+			database["status"] = "down"
+			ready = false
+		}
+
+		stats := a.db.Stats()
+		database["pool"] = envelope{
+			"max_open":      stats.MaxOpenConnections,
+			"open":          stats.OpenConnections,
+			"in_use":        stats.InUse,
+			"idle":          stats.Idle,
+			"wait_count":    stats.WaitCount,
+			"wait_duration": stats.WaitDuration.String(),
+		}
+		checks["database"] = database
+
+		if a.migrator != nil && err == nil {
+			version, dirty, err := a.migrator.Version(ctx)
+			if err != nil {
+				a.contextGetLogger(r).Warn("readiness migration check failed", "error", err.Error())
+				ready = false
+			} else {
+				checks["migrations"] = envelope{
+					"version": version,
+					"latest":  a.migrator.Latest(),
+					"dirty":   dirty,
+				}
+				if dirty || version < a.migrator.Latest() {
+					ready = false
+				}
+			}
+		}
+	}
+
+	status, code := "ready", http.StatusOK
+	switch {
+	case a.shuttingDown.Load():
+		status, code = "shutting down", http.StatusServiceUnavailable
+	case !ready:
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	data := envelope{
+		"status": status,
+		"system_info": map[string]string{
+			"environment": a.config.environment,
+			"version":     appVersion,
+		},
+		"uptime": time.Since(a.startedAt).Round(time.Second).String(),
+		"checks": checks,
+	}
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+	err := a.writeJSON(w, code, data, headers)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
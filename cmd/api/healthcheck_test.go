@@ -0,0 +1,143 @@
+// Filename: cmd/api/healthcheck_test.go
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLiveness(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	for _, path := range []string{"/v1/healthcheck", "/v1/healthcheck/live", "/health"} {
+		t.Run(path, func(t *testing.T) {
+			res := ts.do(t, http.MethodGet, path, "", "")
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.status, http.StatusOK)
+			}
+
+			var got struct {
+				Status string `json:"status"`
+			}
+			res.decode(t, &got)
+			if got.Status != "available" {
+				t.Errorf("got status %q, want %q", got.Status, "available")
+			}
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.do(t, http.MethodGet, "/v1/healthcheck/ready", "", "")
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusOK, res.body)
+	}
+
+	var got struct {
+		Status string `json:"status"`
+		Uptime string `json:"uptime"`
+		Checks struct {
+			Storage  string `json:"storage"`
+			Database *struct {
+				Status string `json:"status"`
+			} `json:"database"`
+			Migrations *struct {
+				Version int64 `json:"version"`
+				Latest  int64 `json:"latest"`
+				Dirty   bool  `json:"dirty"`
+			} `json:"migrations"`
+		} `json:"checks"`
+	}
+	res.decode(t, &got)
+	if got.Status != "ready" || got.Uptime == "" {
+		t.Errorf("unexpected readiness response %s", res.body)
+	}
+	if got.Checks.Storage != app.config.storage {
+		t.Errorf("got storage %q, want %q", got.Checks.Storage, app.config.storage)
+	}
+	if res.header.Get("Cache-Control") != "no-store" {
+		t.Errorf("got Cache-Control %q, want %q", res.header.Get("Cache-Control"), "no-store")
+	}
+
+	if app.db == nil {
+		if got.Checks.Database != nil || got.Checks.Migrations != nil {
+			t.Errorf("memory storage reported database checks: %s", res.body)
+		}
+		return
+	}
+	if got.Checks.Database == nil || got.Checks.Database.Status != "up" {
+		t.Errorf("database not reported up: %s", res.body)
+	}
+	if m := got.Checks.Migrations; m == nil || m.Dirty || m.Version != m.Latest {
+		t.Errorf("unexpected migration status: %s", res.body)
+	}
+}
+
+func TestReadinessFailsDuringShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.shutdownTimeout = 5 * time.Second
+	app.config.shutdownDelay = 500 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: app.routes()}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- app.serveOn(srv, listener)
+	}()
+
+	url := "http://" + listener.Addr().String()
+	probe := func(path string) int {
+		res, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := probe("/v1/healthcheck/ready"); status != http.StatusOK {
+		t.Fatalf("got readiness %d before shutdown, want %d", status, http.StatusOK)
+	}
+
+	err = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !app.shuttingDown.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("server never started shutting down")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The server keeps serving through the delay with readiness failing
+	if status := probe("/v1/healthcheck/ready"); status != http.StatusServiceUnavailable {
+		t.Errorf("got readiness %d while draining, want %d", status, http.StatusServiceUnavailable)
+	}
+	if status := probe("/v1/healthcheck/live"); status != http.StatusOK {
+		t.Errorf("got liveness %d while draining, want %d", status, http.StatusOK)
+	}
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Fatalf("serveOn returned error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
@@ -6,14 +6,16 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/url" 
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
 )
 
-
 type envelope map[string]any
 
 func (a *applicationDependencies) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
@@ -41,6 +43,37 @@ func (a *applicationDependencies) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
+func (a *applicationDependencies) readVersionParam(r *http.Request) (int32, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	version, err := strconv.ParseInt(params.ByName("version"), 10, 32)
+	if err != nil || version < 1 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return int32(version), nil
+}
+
+// readNamespaceParam returns the namespace named in the URL
+func (a *applicationDependencies) readNamespaceParam(r *http.Request) (string, error) {
+	namespace := httprouter.ParamsFromContext(r.Context()).ByName("ns")
+	if !validator.Matches(namespace, data.NamespaceRX) {
+		return "", errors.New("invalid namespace parameter")
+	}
+	return namespace, nil
+}
+
+// readTargetParams returns the target named in the URL
+func (a *applicationDependencies) readTargetParams(r *http.Request) (data.Target, error) {
+	namespace, err := a.readNamespaceParam(r)
+	if err != nil {
+		return data.Target{}, err
+	}
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	if !validator.Matches(id, data.TargetIDRX) {
+		return data.Target{}, errors.New("invalid target id parameter")
+	}
+	return data.Target{Namespace: namespace, ID: id}, nil
+}
+
 func (a *applicationDependencies) readJSON(w http.ResponseWriter, r *http.Request, destination any) error {
 	maxBytes := 256_000
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
@@ -83,15 +116,57 @@ func (a *applicationDependencies) readJSON(w http.ResponseWriter, r *http.Reques
 	return nil
 }
 
-// for pagination
-func (a *applicationDependencies) readInt(q url.Values, key string, defaultValue int) int {
+// readInt reads an integer from the query string. A missing value gives
+// defaultValue; a malformed one is recorded on v.
+func (a *applicationDependencies) readInt(q url.Values, key string, defaultValue int, v *validator.Validator) int {
 	valueStr := q.Get(key)
 	if valueStr == "" {
 		return defaultValue
 	}
 	value, err := strconv.Atoi(valueStr)
 	if err != nil {
+		v.AddError(key, "must be an integer value")
 		return defaultValue
 	}
 	return value
 }
+
+// readTime parses an RFC 3339 timestamp from the query string. A missing
+// value gives nil; a malformed one is recorded on v.
+func (a *applicationDependencies) readTime(q url.Values, key string, v *validator.Validator) *time.Time {
+	valueStr := q.Get(key)
+	if valueStr == "" {
+		return nil
+	}
+	value, err := time.Parse(time.RFC3339, valueStr)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return nil
+	}
+	return &value
+}
+
+// commentStore returns the comment store, logging through the request's logger
+func (a *applicationDependencies) commentStore(r *http.Request) data.CommentStore {
+	return a.commentModel.WithLogger(a.contextGetLogger(r))
+}
+
+// reportStore returns the report store, logging through the request's logger
+func (a *applicationDependencies) reportStore(r *http.Request) data.ReportStore {
+	return a.reportModel.WithLogger(a.contextGetLogger(r))
+}
+
+// background runs fn in its own goroutine, tracked by the application's
+// WaitGroup, and logs any panic instead of crashing the server.
+func (a *applicationDependencies) background(fn func()) {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				a.logger.Error(fmt.Sprintf("%v", err))
+			}
+		}()
+		fn()
+	}()
+}
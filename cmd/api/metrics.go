@@ -0,0 +1,239 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// unmatchedRoute labels requests that never reached a route, because none
+// matched or middleware such as the rate limiter answered first. Using the
+// raw path instead would let scanners create any number of series.
+const unmatchedRoute = "unmatched"
+
+// metricMethods are the request methods kept as labels; anything else is
+// counted as "other"
+var metricMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+	http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// metrics collects the counters served by /metrics in the Prometheus text
+// exposition format
+type metrics struct {
+	mu                 sync.Mutex
+	requests           map[requestSeries]*histogram
+	validationFailures map[string]int64
+	inFlight           atomic.Int64
+	panics             atomic.Int64
+}
+
+// requestSeries identifies one set of request labels
+type requestSeries struct {
+	route  string
+	method string
+	status int
+}
+
+type histogram struct {
+	buckets []int64 // observations per bucket, not cumulative
+	count   int64
+	sum     float64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:           make(map[requestSeries]*histogram),
+		validationFailures: make(map[string]int64),
+	}
+}
+
+// observeRequest records a completed request
+func (m *metrics) observeRequest(route, method string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series := requestSeries{route: route, method: method, status: status}
+	h, found := m.requests[series]
+	if !found {
+		h = &histogram{buckets: make([]int64, len(latencyBuckets))}
+		m.requests[series] = h
+	}
+
+	seconds := duration.Seconds()
+	if i, _ := slices.BinarySearch(latencyBuckets, seconds); i < len(latencyBuckets) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// observeValidationFailures counts one failure for every field in errors
+func (m *metrics) observeValidationFailures(errors map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for field := range errors {
+		m.validationFailures[field]++
+	}
+}
+
+// escapeLabel escapes a label value for the text exposition format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// write outputs every metric, with pool statistics for db if it is not nil
+func (m *metrics) write(w io.Writer, db *sql.DB) {
+	m.mu.Lock()
+	series := make([]requestSeries, 0, len(m.requests))
+	for s := range m.requests {
+		series = append(series, s)
+	}
+	slices.SortFunc(series, func(a, b requestSeries) int {
+		return cmp.Or(strings.Compare(a.route, b.route), strings.Compare(a.method, b.method), cmp.Compare(a.status, b.status))
+	})
+
+	fmt.Fprintln(w, "# HELP http_requests_total Completed HTTP requests by route, method and status.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	for _, s := range series {
+		fmt.Fprintf(w, "http_requests_total{route=\"%s\",method=\"%s\",status=\"%d\"} %d\n", escapeLabel(s.route), escapeLabel(s.method), s.status, m.requests[s].count)
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds Time taken to serve HTTP requests by route, method and status.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	for _, s := range series {
+		h := m.requests[s]
+		labels := fmt.Sprintf("route=\"%s\",method=\"%s\",status=\"%d\"", escapeLabel(s.route), escapeLabel(s.method), s.status)
+		cumulative := int64(0)
+		for i, bound := range latencyBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	fields := make([]string, 0, len(m.validationFailures))
+	for field := range m.validationFailures {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+
+	fmt.Fprintln(w, "# HELP validation_failures_total Request fields rejected by validation.")
+	fmt.Fprintln(w, "# TYPE validation_failures_total counter")
+	for _, field := range fields {
+		fmt.Fprintf(w, "validation_failures_total{field=\"%s\"} %d\n", escapeLabel(field), m.validationFailures[field])
+	}
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP http_requests_in_flight HTTP requests currently being served.")
+	fmt.Fprintln(w, "# TYPE http_requests_in_flight gauge")
+	fmt.Fprintf(w, "http_requests_in_flight %d\n", m.inFlight.Load())
+
+	fmt.Fprintln(w, "# HELP http_panics_total Panics recovered while serving HTTP requests.")
+	fmt.Fprintln(w, "# TYPE http_panics_total counter")
+	fmt.Fprintf(w, "http_panics_total %d\n", m.panics.Load())
+
+	if db == nil {
+		return
+	}
+	stats := db.Stats()
+	for _, metric := range []struct {
+		name, kind, help string
+		value            string
+	}{
+		{"db_max_open_connections", "gauge", "Maximum number of open connections to the database.", strconv.Itoa(stats.MaxOpenConnections)},
+		{"db_open_connections", "gauge", "Established connections, in use and idle.", strconv.Itoa(stats.OpenConnections)},
+		{"db_in_use_connections", "gauge", "Connections currently in use.", strconv.Itoa(stats.InUse)},
+		{"db_idle_connections", "gauge", "Idle connections.", strconv.Itoa(stats.Idle)},
+		{"db_wait_count_total", "counter", "Connections waited for.", strconv.FormatInt(stats.WaitCount, 10)},
+		{"db_wait_duration_seconds_total", "counter", "Time spent waiting for a connection.", formatFloat(stats.WaitDuration.Seconds())},
+		{"db_max_idle_closed_total", "counter", "Connections closed due to the idle connection limit.", strconv.FormatInt(stats.MaxIdleClosed, 10)},
+		{"db_max_idle_time_closed_total", "counter", "Connections closed due to the maximum idle time.", strconv.FormatInt(stats.MaxIdleTimeClosed, 10)},
+		{"db_max_lifetime_closed_total", "counter", "Connections closed due to the maximum connection lifetime.", strconv.FormatInt(stats.MaxLifetimeClosed, 10)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
+
+// collectMetrics counts every request by the route it matched and times it
+func (a *applicationDependencies) collectMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.metrics.inFlight.Add(1)
+		defer a.metrics.inFlight.Add(-1)
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		route := unmatchedRoute
+		if info := a.contextGetRequestInfo(r); info != nil && info.route != "" {
+			route = info.route
+		}
+		method := r.Method
+		if !slices.Contains(metricMethods, method) {
+			method = "other"
+		}
+		a.metrics.observeRequest(route, method, sw.statusCode(), time.Since(start))
+	})
+}
+
+// tagRoute records the pattern a request matched, which labels its metrics
+func (a *applicationDependencies) tagRoute(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if info := a.contextGetRequestInfo(r); info != nil {
+			info.route = pattern
+		}
+		next(w, r)
+	}
+}
+
+func (a *applicationDependencies) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	a.metrics.write(w, a.db)
+}
+
+// requireMetricsAuth protects the metrics endpoint with HTTP basic
+// authentication against the configured credentials
+func (a *applicationDependencies) requireMetricsAuth(next http.HandlerFunc) http.HandlerFunc {
+	// Comparing hashes keeps the comparison constant-time whatever the lengths
+	wantUsername := sha256.Sum256([]byte(a.config.metrics.username))
+	wantPassword := sha256.Sum256([]byte(a.config.metrics.password))
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if ok {
+			gotUsername := sha256.Sum256([]byte(username))
+			gotPassword := sha256.Sum256([]byte(password))
+			usernameMatch := subtle.ConstantTimeCompare(gotUsername[:], wantUsername[:]) == 1
+			passwordMatch := subtle.ConstantTimeCompare(gotPassword[:], wantPassword[:]) == 1
+			if usernameMatch && passwordMatch {
+				next(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="metrics", charset="UTF-8"`)
+		a.invalidCredentialsResponse(w, r)
+	}
+}
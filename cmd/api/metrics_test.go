@@ -0,0 +1,96 @@
+// Filename: cmd/api/metrics_test.go
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.metrics.username = "prometheus"
+	app.config.metrics.password = "scrape-secret"
+	ts := newTestServer(t, app.routes())
+
+	ts.do(t, http.MethodGet, "/v1/comments", "", "")
+	ts.do(t, http.MethodGet, "/v1/comments", "", "")
+	ts.do(t, http.MethodGet, "/v1/comments/999", "", "")
+	ts.do(t, http.MethodGet, "/v1/comments/trash", "", "")
+	ts.do(t, http.MethodGet, "/wp-login.php", "", "")
+	ts.do(t, http.MethodGet, "/v1/comments?page=0&sort=nope", "", "")
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		wantStatus int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "prometheus", "guess", http.StatusUnauthorized},
+		{"valid credentials", "prometheus", "scrape-secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Fatalf("got status %d, want %d", res.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+	var body strings.Builder
+	app.metrics.write(&body, nil)
+
+	for _, want := range []string{
+		`http_requests_total{route="/v1/comments",method="GET",status="200"} 2`,
+		`http_requests_total{route="/v1/comments/:id",method="GET",status="404"} 1`,
+		`http_requests_total{route="/v1/comments/trash",method="GET",status="401"} 1`,
+		`http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`http_requests_total{route="/v1/comments",method="GET",status="422"} 1`,
+		`http_request_duration_seconds_bucket{route="/v1/comments",method="GET",status="200",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{route="/v1/comments",method="GET",status="200"} 2`,
+		`validation_failures_total{field="page"} 1`,
+		`validation_failures_total{field="sort"} 1`,
+		`http_requests_in_flight 0`,
+		`http_panics_total 0`,
+	} {
+		if !strings.Contains(body.String(), want+"\n") {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
+
+func TestMetricsCountPanics(t *testing.T) {
+	app := newTestApplication(t)
+
+	handler := app.collectMetrics(app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	})))
+	ts := newTestServer(t, handler)
+	ts.do(t, http.MethodGet, "/", "", "")
+
+	var body strings.Builder
+	app.metrics.write(&body, nil)
+	for _, want := range []string{
+		`http_panics_total 1`,
+		`http_requests_total{route="unmatched",method="GET",status="500"} 1`,
+	} {
+		if !strings.Contains(body.String(), want+"\n") {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
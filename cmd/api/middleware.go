@@ -1,11 +1,103 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"regexp"
 	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
 )
 
+// requestIDRX matches the client-supplied request IDs we are willing to
+// repeat in logs and responses
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID tags the request with the client's X-Request-ID, or a new random
+// one, and gives it a logger that includes the ID in every record
+func (a *applicationDependencies) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			randomBytes := make([]byte, 16)
+			rand.Read(randomBytes)
+			id = hex.EncodeToString(randomBytes)
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		info := &requestInfo{
+			id:     id,
+			logger: a.logger.With("request_id", id),
+		}
+		next.ServeHTTP(w, a.contextSetRequestInfo(r, info))
+	})
+}
+
+// statusWriter records the status code and body size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode returns the status sent, which is 200 if the handler never
+// wrote anything
+func (w *statusWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// logRequests writes one access-log record per request once it completes.
+// It must run inside requestID.
+func (a *applicationDependencies) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		next.ServeHTTP(sw, r)
+
+		a.contextGetLogger(r).Info("request",
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", sw.statusCode(),
+			"bytes", sw.bytes,
+			"duration", time.Since(start),
+			"remote_ip", clientIP(r, a.config.limiter.trustedProxies),
+		)
+	})
+}
+
 // recoverPanic is middleware that recovers from panics in handlers
 // and sends a 500 Internal Server Error response in JSON.
 func (a *applicationDependencies) recoverPanic(next http.Handler) http.Handler {
@@ -14,6 +106,7 @@ func (a *applicationDependencies) recoverPanic(next http.Handler) http.Handler {
 		defer func() {
 			// recover() checks for panics
 			if err := recover(); err != nil {
+				a.metrics.panics.Add(1)
 				w.Header().Set("Connection", "close")
 				a.serverErrorResponse(w, r, fmt.Errorf("%v", err))
 			}
@@ -29,11 +122,12 @@ func (a *applicationDependencies) enableCORS(next http.Handler) http.Handler {
 
 		if origin != "" && slices.Contains(a.config.cors.trustedOrigins, origin) {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-ID")
 			w.Header().Set("Vary", "Origin")
 
 			if r.Method == http.MethodOptions {
-				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PATCH, DELETE")
-				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, GET, POST, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, X-Request-ID")
 				w.WriteHeader(http.StatusOK)
 				return
 			}
@@ -41,4 +135,154 @@ func (a *applicationDependencies) enableCORS(next http.Handler) http.Handler {
 
 		next.ServeHTTP(w, r)
 	})
-}
\ No newline at end of file
+}
+
+// authenticate looks up the user behind a bearer token in the Authorization
+// header and stores it in the request context. Requests without the header
+// carry the anonymous user.
+func (a *applicationDependencies) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+		if authorizationHeader == "" {
+			r = a.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+		token := headerParts[1]
+
+		v := validator.New()
+		data.ValidateTokenPlaintext(v, token)
+		if !v.IsEmpty() {
+			a.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		user, err := a.userModel.GetForToken(r.Context(), data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.invalidAuthenticationTokenResponse(w, r)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = a.contextSetUser(r, user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuthenticatedUser rejects anonymous requests with a 401
+func (a *applicationDependencies) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if user.IsAnonymous() {
+			a.authenticationRequiredResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireActivatedUser additionally rejects users who have not activated
+// their account yet
+func (a *applicationDependencies) requireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		if !user.Activated {
+			a.inactiveAccountResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	return a.requireAuthenticatedUser(fn)
+}
+
+// requirePermission only lets activated users holding the given permission
+// code through
+func (a *applicationDependencies) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+		permissions, err := a.permissionModel.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+		if !permissions.Include(code) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return a.requireActivatedUser(fn)
+}
+
+// probePaths are the health checks that orchestrators poll, which must keep
+// answering however busy the address they poll from is
+var probePaths = map[string]bool{
+	"/v1/healthcheck/live":  true,
+	"/v1/healthcheck/ready": true,
+	"/health":               true,
+}
+
+// rateLimit applies the global per-IP budget to every request but the probes
+func (a *applicationDependencies) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.config.limiter.enabled || probePaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "ip:" + clientIP(r, a.config.limiter.trustedProxies)
+		if !a.applyRateLimit(w, r, a.limiters.global, key) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitRoute applies a route-specific budget on top of the global one.
+// Authenticated users get a bucket of their own; everyone else is keyed by IP.
+func (a *applicationDependencies) rateLimitRoute(limiter *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.config.limiter.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := "ip:" + clientIP(r, a.config.limiter.trustedProxies)
+		if user := a.contextGetUser(r); !user.IsAnonymous() {
+			key = fmt.Sprintf("user:%d", user.ID)
+		}
+		if !a.applyRateLimit(w, r, limiter, key) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// applyRateLimit takes a token for key, sets the RateLimit-* headers and
+// sends a 429 if the bucket was empty. It reports whether to carry on.
+func (a *applicationDependencies) applyRateLimit(w http.ResponseWriter, r *http.Request, limiter *rateLimiter, key string) bool {
+	result := limiter.allow(key, time.Now())
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.reset.Seconds()))))
+
+	if !result.allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(result.retryAfter.Seconds()))))
+		a.rateLimitExceededResponse(w, r)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+// Filename: cmd/api/middleware_test.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"victortillett.net/basic/internal/data"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name        string
+		origin      string
+		wantOrigin  string
+		wantMethods string
+	}{
+		{"trusted origin", testTrustedOrigin, testTrustedOrigin, "OPTIONS, GET, POST, PUT, PATCH, DELETE"},
+		{"untrusted origin", "http://evil.example", "", ""},
+		{"no origin", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := []string{"Access-Control-Request-Method", http.MethodPatch}
+			if tt.origin != "" {
+				headers = append(headers, "Origin", tt.origin)
+			}
+
+			res := ts.do(t, http.MethodOptions, "/v1/comments/1", "", "", headers...)
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.status, http.StatusOK)
+			}
+			if got := res.header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+			if got := res.header.Get("Access-Control-Allow-Methods"); got != tt.wantMethods {
+				t.Errorf("got Access-Control-Allow-Methods %q, want %q", got, tt.wantMethods)
+			}
+		})
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.do(t, http.MethodGet, "/v1/comments", "", "", "Origin", testTrustedOrigin)
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusOK)
+	}
+	if got := res.header.Get("Access-Control-Allow-Origin"); got != testTrustedOrigin {
+		t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, testTrustedOrigin)
+	}
+	if got := res.header.Get("Access-Control-Expose-Headers"); got != "ETag, X-Request-ID" {
+		t.Errorf("got Access-Control-Expose-Headers %q, want %q", got, "ETag, X-Request-ID")
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	app := newTestApplication(t)
+
+	handler := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	}))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+	if got := rr.Header().Get("Connection"); got != "close" {
+		t.Errorf("got Connection %q, want %q", got, "close")
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q, want %q", got, "application/json")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	token := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"valid token", "Bearer " + token, http.StatusCreated},
+		{"wrong scheme", "Basic " + token, http.StatusUnauthorized},
+		{"malformed header", "Bearer", http.StatusUnauthorized},
+		{"short token", "Bearer abc", http.StatusUnauthorized},
+		{"unknown token", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/comments", "", `{"content": "hello"}`, "Authorization", tt.authorization)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status == http.StatusUnauthorized && res.header.Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("got WWW-Authenticate %q, want %q", res.header.Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	tests := []struct {
+		name   string
+		sent   string
+		wantID string // empty means a generated ID
+	}{
+		{"generated", "", ""},
+		{"echoed", "client-42.retry:1", "client-42.retry:1"},
+		{"unsafe replaced", "bad id\twith spaces", ""},
+		{"too long replaced", strings.Repeat("a", 129), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var headers []string
+			if tt.sent != "" {
+				headers = []string{"X-Request-ID", tt.sent}
+			}
+
+			res := ts.do(t, http.MethodGet, "/v1/comments/999", "", "", headers...)
+			id := res.header.Get("X-Request-ID")
+			switch {
+			case tt.wantID != "" && id != tt.wantID:
+				t.Errorf("got X-Request-ID %q, want %q", id, tt.wantID)
+			case tt.wantID == "" && !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id):
+				t.Errorf("got X-Request-ID %q, want a generated ID", id)
+			}
+
+			var got struct {
+				RequestID string `json:"request_id"`
+			}
+			res.decode(t, &got)
+			if got.RequestID != id {
+				t.Errorf("got request_id %q in the error, want %q", got.RequestID, id)
+			}
+		})
+	}
+}
+
+func TestLogRequests(t *testing.T) {
+	app := newTestApplication(t)
+	var buf bytes.Buffer
+	app.logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	ts := newTestServer(t, app.routes())
+
+	token := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	res := ts.do(t, http.MethodPost, "/v1/comments", token, `{"content": "logged"}`, "X-Request-ID", "req-1")
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusCreated)
+	}
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		UserID    int64  `json:"user_id"`
+		Method    string `json:"method"`
+		URI       string `json:"uri"`
+		Status    int    `json:"status"`
+		Bytes     int    `json:"bytes"`
+		RemoteIP  string `json:"remote_ip"`
+	}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("expected a single JSON log record, got %q: %v", buf.String(), err)
+	}
+
+	if entry.Msg != "request" || entry.RequestID != "req-1" || entry.Method != http.MethodPost || entry.URI != "/v1/comments" {
+		t.Errorf("unexpected access log record %+v", entry)
+	}
+	if entry.Status != http.StatusCreated || entry.Bytes != len(res.body) {
+		t.Errorf("got status %d and %d bytes, want %d and %d", entry.Status, entry.Bytes, http.StatusCreated, len(res.body))
+	}
+	if entry.UserID == 0 {
+		t.Error("access log record has no user_id")
+	}
+	if entry.RemoteIP != "127.0.0.1" {
+		t.Errorf("got remote_ip %q, want %q", entry.RemoteIP, "127.0.0.1")
+	}
+}
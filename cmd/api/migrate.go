@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"victortillett.net/basic/internal/migrate"
+)
+
+// runMigration performs the -migrate action
+func runMigration(migrator *migrate.Migrator, settings serverConfig, logger *slog.Logger) error {
+	// Migrations may rewrite whole tables, so they get no deadline
+	ctx := context.Background()
+
+	switch settings.migrate.action {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrations applied", "count", applied, "version", migrator.Latest())
+	case "down":
+		reverted, err := migrator.Down(ctx, settings.migrate.steps)
+		if err != nil {
+			return err
+		}
+		version, _, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("migrations reverted", "count", reverted, "version", version)
+	case "force":
+		err := migrator.Force(ctx, settings.migrate.version)
+		if err != nil {
+			return err
+		}
+		logger.Info("migration version forced", "version", settings.migrate.version)
+	case "status":
+		version, dirty, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Info("migration status", "version", version, "dirty", dirty, "latest", migrator.Latest())
+		for _, migration := range migrator.Pending(version) {
+			logger.Info("pending migration", "version", migration.Version, "name", migration.Name)
+		}
+	}
+	return nil
+}
+
+// checkSchema refuses to serve against a database that is missing embedded
+// migrations or was left dirty by a failed one
+func checkSchema(migrator *migrate.Migrator, logger *slog.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dirty:
+		return fmt.Errorf("database schema version %d is dirty, fix it and run -migrate=force", version)
+	case version < migrator.Latest():
+		return fmt.Errorf("database schema version %d is behind %d, run -migrate=up", version, migrator.Latest())
+	case version > migrator.Latest():
+		logger.Warn("database schema is newer than this build", "version", version, "latest", migrator.Latest())
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
+)
+
+// viewer describes the current user for deciding which comments they see
+func (a *applicationDependencies) viewer(r *http.Request) (data.Viewer, error) {
+	user := a.contextGetUser(r)
+	if user.IsAnonymous() {
+		return data.Viewer{}, nil
+	}
+	permissions, err := a.permissionModel.GetAllForUser(r.Context(), user.ID)
+	if err != nil {
+		return data.Viewer{}, err
+	}
+	return data.Viewer{UserID: user.ID, Moderator: permissions.Include(data.PermissionCommentsModerate)}, nil
+}
+
+// admitComment decides whether the current user may comment on target, or
+// reply to a comment on it, and with which status the comment starts. It
+// sends the error response itself and reports whether to carry on.
+// Comments without a target are always accepted.
+func (a *applicationDependencies) admitComment(w http.ResponseWriter, r *http.Request, viewer data.Viewer, target data.Target, reply bool) (string, bool) {
+	premoderate := a.config.moderation.premoderate
+
+	if !target.IsZero() {
+		namespace, err := a.namespaceModel.Get(r.Context(), target.Namespace)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return "", false
+		}
+
+		open := namespace.Status == data.NamespaceOpen ||
+			(namespace.Status == data.NamespaceClosed && reply) ||
+			(namespace.Status == data.NamespaceLocked && viewer.Moderator)
+		if !open {
+			a.targetClosedResponse(w, r, namespace.Status)
+			return "", false
+		}
+		premoderate = premoderate || namespace.Premoderate
+	}
+
+	// Moderators would only have to approve their own comments
+	if premoderate && !viewer.Moderator {
+		return data.CommentPending, true
+	}
+	return data.CommentApproved, true
+}
+
+// hideReplies removes the replies in a thread that viewer may not see,
+// together with the replies below them
+func hideReplies(viewer data.Viewer, comment *data.Comment) {
+	comment.Replies = slices.DeleteFunc(comment.Replies, func(reply *data.Comment) bool {
+		return !viewer.CanSee(reply)
+	})
+	for _, reply := range comment.Replies {
+		hideReplies(viewer, reply)
+	}
+}
+
+// listModerationQueueHandler lists the comments waiting for a moderator,
+// oldest first. The status parameter reviews rejected or spam comments
+// instead.
+func (a *applicationDependencies) listModerationQueueHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	v := validator.New()
+	filters := data.Filters{
+		Page:         a.readInt(query, "page", 1, v),
+		PageSize:     a.readInt(query, "page_size", 10, v),
+		Sort:         query.Get("sort"),
+		SortSafelist: []string{"id", "created", "-id", "-created"},
+	}
+	if filters.Sort == "" {
+		filters.Sort = "id"
+	}
+	data.ValidateFilters(v, filters)
+
+	status := query.Get("status")
+	if status == "" {
+		status = data.CommentPending
+	}
+	v.Check(validator.PermittedValue(status, data.CommentPending, data.CommentRejected, data.CommentSpam), "status", "must be pending, rejected or spam")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	comments, metadata, err := a.commentStore(r).GetByStatus(r.Context(), status, filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	dataResponse := envelope{
+		"comments": comments,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) approveCommentHandler(w http.ResponseWriter, r *http.Request) {
+	a.moderateComment(w, r, data.CommentApproved, "")
+}
+
+// rejectCommentHandler rejects a comment for the reason given, or as spam
+func (a *applicationDependencies) rejectCommentHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Reason string `json:"reason"`
+		Spam   bool   `json:"spam"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	status := data.CommentRejected
+	if incomingData.Spam {
+		status = data.CommentSpam
+	}
+	a.moderateComment(w, r, status, incomingData.Reason)
+}
+
+// moderateComment gives the comment in the URL a status and responds with
+// the moderated comment
+func (a *applicationDependencies) moderateComment(w http.ResponseWriter, r *http.Request, status, reason string) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateModeration(v, status, reason)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	moderated, err := a.commentStore(r).Moderate(r.Context(), []int64{id}, status, reason, a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if len(moderated) == 0 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, data.Viewer{UserID: a.contextGetUser(r).ID, Moderator: true})
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"comment": comment}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// bulkModerateHandler gives many comments the same status at once and
+// responds with the ids of those that exist
+func (a *applicationDependencies) bulkModerateHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		IDs    []int64 `json:"ids"`
+		Status string  `json:"status"`
+		Reason string  `json:"reason"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(incomingData.IDs) > 0, "ids", "must contain at least one id")
+	v.Check(len(incomingData.IDs) <= 100, "ids", "must not contain more than 100 ids")
+	data.ValidateModeration(v, incomingData.Status, incomingData.Reason)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	moderated, err := a.commentStore(r).Moderate(r.Context(), incomingData.IDs, incomingData.Status, incomingData.Reason, a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"moderated": moderated}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
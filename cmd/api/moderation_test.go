@@ -0,0 +1,335 @@
+// Filename: cmd/api/moderation_test.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"victortillett.net/basic/internal/data"
+)
+
+func commentIDs(comments []data.Comment) []int64 {
+	ids := []int64{}
+	for _, comment := range comments {
+		ids = append(ids, comment.ID)
+	}
+	return ids
+}
+
+func TestPremoderation(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.moderation.premoderate = true
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	pending := createTargetComment(t, ts, alice, "wait for me", "articles", "1")
+	if pending.Status != data.CommentPending {
+		t.Fatalf("got status %q, want %q", pending.Status, data.CommentPending)
+	}
+	approved := createTargetComment(t, ts, moderator, "trusted", "articles", "1")
+	if approved.Status != data.CommentApproved {
+		t.Fatalf("got status %q for a moderator's comment, want %q", approved.Status, data.CommentApproved)
+	}
+	path := fmt.Sprintf("/v1/comments/%d", pending.ID)
+
+	viewers := []struct {
+		name    string
+		token   string
+		canSee  bool
+		wantIDs []int64
+	}{
+		{"anonymous", "", false, []int64{approved.ID}},
+		{"other user", bob, false, []int64{approved.ID}},
+		{"author", alice, true, []int64{pending.ID, approved.ID}},
+		{"moderator", moderator, true, []int64{pending.ID, approved.ID}},
+	}
+
+	for _, viewer := range viewers {
+		t.Run(viewer.name, func(t *testing.T) {
+			wantStatus := http.StatusNotFound
+			if viewer.canSee {
+				wantStatus = http.StatusOK
+			}
+			res := ts.do(t, http.MethodGet, path, viewer.token, "")
+			if res.status != wantStatus {
+				t.Errorf("got status %d showing the pending comment, want %d", res.status, wantStatus)
+			}
+
+			for _, list := range []string{"/v1/comments", "/v1/comments?cursor=", "/v1/targets/articles/1/comments"} {
+				res := ts.do(t, http.MethodGet, list, viewer.token, "")
+				var got commentListResponse
+				res.decode(t, &got)
+				if fmt.Sprint(commentIDs(got.Comments)) != fmt.Sprint(viewer.wantIDs) {
+					t.Errorf("%s: got comments %v, want %v", list, commentIDs(got.Comments), viewer.wantIDs)
+				}
+			}
+		})
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/namespaces/articles/counts?id=1", "", "")
+	var counts struct {
+		Counts map[string]int `json:"counts"`
+	}
+	res.decode(t, &counts)
+	if counts.Counts["1"] != 1 {
+		t.Errorf("got count %d, want only the approved comment counted", counts.Counts["1"])
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/moderation/queue", moderator, "")
+	var queue commentListResponse
+	res.decode(t, &queue)
+	if fmt.Sprint(commentIDs(queue.Comments)) != fmt.Sprint([]int64{pending.ID}) {
+		t.Errorf("got queue %v, want [%d]", commentIDs(queue.Comments), pending.ID)
+	}
+
+	res = ts.do(t, http.MethodPost, path+"/approve", moderator, "")
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d approving: %s", res.status, res.body)
+	}
+	res = ts.do(t, http.MethodGet, path, "", "")
+	if res.status != http.StatusOK {
+		t.Errorf("got status %d showing the approved comment, want %d", res.status, http.StatusOK)
+	}
+}
+
+func TestNamespacePremoderation(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	res := ts.do(t, http.MethodPut, "/v1/namespaces/tickets", moderator, `{"premoderate": true}`)
+	var got namespaceResponse
+	res.decode(t, &got)
+	if !got.Namespace.Premoderate || got.Namespace.Status != data.NamespaceOpen {
+		t.Fatalf("got namespace %+v, want it open and premoderated", got.Namespace)
+	}
+
+	if comment := createTargetComment(t, ts, alice, "on a ticket", "tickets", "7"); comment.Status != data.CommentPending {
+		t.Errorf("got status %q in a premoderated namespace, want %q", comment.Status, data.CommentPending)
+	}
+	if comment := createTargetComment(t, ts, alice, "on an article", "articles", "7"); comment.Status != data.CommentApproved {
+		t.Errorf("got status %q in another namespace, want %q", comment.Status, data.CommentApproved)
+	}
+}
+
+func TestRejectComment(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+	comment := ts.createTestComment(t, alice, "buy now")
+	path := fmt.Sprintf("/v1/comments/%d/reject", comment.ID)
+
+	tests := []struct {
+		name       string
+		token      string
+		path       string
+		body       string
+		wantStatus int
+		wantState  string
+	}{
+		{"without permission", alice, path, `{"reason": "mine"}`, http.StatusForbidden, ""},
+		{"without reason", moderator, path, `{}`, http.StatusUnprocessableEntity, ""},
+		{"missing comment", moderator, "/v1/comments/999/reject", `{"reason": "gone"}`, http.StatusNotFound, ""},
+		{"rejected", moderator, path, `{"reason": "off topic"}`, http.StatusOK, data.CommentRejected},
+		{"spam", moderator, path, `{"spam": true}`, http.StatusOK, data.CommentSpam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, tt.path, tt.token, tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if tt.wantState == "" {
+				return
+			}
+			var got commentResponse
+			res.decode(t, &got)
+			if got.Comment.Status != tt.wantState {
+				t.Errorf("got status %q, want %q", got.Comment.Status, tt.wantState)
+			}
+		})
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/moderation/queue?status=spam", moderator, "")
+	var queue commentListResponse
+	res.decode(t, &queue)
+	if fmt.Sprint(commentIDs(queue.Comments)) != fmt.Sprint([]int64{comment.ID}) {
+		t.Errorf("got spam queue %v, want [%d]", commentIDs(queue.Comments), comment.ID)
+	}
+
+	// The author still sees their comment and can edit it
+	res = ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d", comment.ID), alice, "")
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d showing a rejected comment to its author", res.status)
+	}
+	res = ts.do(t, http.MethodPatch, fmt.Sprintf("/v1/comments/%d", comment.ID), alice, `{"content": "sorry"}`, "If-Match", res.header.Get("ETag"))
+	if res.status != http.StatusOK {
+		t.Errorf("got status %d updating with the ETag of a rejected comment: %s", res.status, res.body)
+	}
+}
+
+func TestBulkModerate(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.moderation.premoderate = true
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+	first := ts.createTestComment(t, alice, "first")
+	second := ts.createTestComment(t, alice, "second")
+
+	tests := []struct {
+		name          string
+		body          string
+		wantStatus    int
+		wantModerated []int64
+	}{
+		{"no ids", `{"ids": [], "status": "approved"}`, http.StatusUnprocessableEntity, nil},
+		{"pending", fmt.Sprintf(`{"ids": [%d], "status": "pending"}`, first.ID), http.StatusUnprocessableEntity, nil},
+		{"approved", fmt.Sprintf(`{"ids": [%d, 999, %d], "status": "approved"}`, second.ID, first.ID), http.StatusOK, []int64{first.ID, second.ID}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/moderation/bulk", moderator, tt.body)
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if tt.wantModerated == nil {
+				return
+			}
+			var got struct {
+				Moderated []int64 `json:"moderated"`
+			}
+			res.decode(t, &got)
+			if fmt.Sprint(got.Moderated) != fmt.Sprint(tt.wantModerated) {
+				t.Errorf("got moderated %v, want %v", got.Moderated, tt.wantModerated)
+			}
+		})
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/comments", "", "")
+	var got commentListResponse
+	res.decode(t, &got)
+	if len(got.Comments) != 2 {
+		t.Errorf("got %d public comments after approving both, want 2", len(got.Comments))
+	}
+}
+
+func TestThreadHidesPendingReplies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	root := ts.createTestComment(t, alice, "root")
+
+	app.config.moderation.premoderate = true
+	res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/comments/%d/replies", root.ID), alice, `{"content": "pending reply"}`)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d replying: %s", res.status, res.body)
+	}
+
+	var thread struct {
+		Thread data.Comment `json:"thread"`
+	}
+	path := fmt.Sprintf("/v1/comments/%d/thread", root.ID)
+
+	ts.do(t, http.MethodGet, path, "", "").decode(t, &thread)
+	if len(thread.Thread.Replies) != 0 || thread.Thread.ReplyCount != 0 {
+		t.Errorf("got %d replies (reply_count %d) for the public, want none", len(thread.Thread.Replies), thread.Thread.ReplyCount)
+	}
+
+	ts.do(t, http.MethodGet, path, alice, "").decode(t, &thread)
+	if len(thread.Thread.Replies) != 1 {
+		t.Errorf("got %d replies for the author, want 1", len(thread.Thread.Replies))
+	}
+}
+
+func TestReplyCountHidesPendingReplies(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	reply := func(token string, parentID int64, content string) int64 {
+		t.Helper()
+		res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/comments/%d/replies", parentID), token, fmt.Sprintf(`{"content": %q}`, content))
+		if res.status != http.StatusCreated {
+			t.Fatalf("got status %d replying: %s", res.status, res.body)
+		}
+		var got commentResponse
+		res.decode(t, &got)
+		return got.Comment.ID
+	}
+
+	// root has an approved reply and a pending one; the approved reply has a
+	// pending reply of its own
+	root := ts.createTestComment(t, alice, "root")
+	app.config.moderation.premoderate = true
+	approved := reply(moderator, root.ID, "approved reply")
+	reply(bob, root.ID, "pending reply")
+	reply(bob, approved, "pending nested reply")
+
+	counts := func(token string) map[string]int {
+		t.Helper()
+		got := make(map[string]int)
+
+		var display commentResponse
+		ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d", root.ID), token, "").decode(t, &display)
+		got["display"] = display.Comment.ReplyCount
+
+		var page, cursor commentListResponse
+		ts.do(t, http.MethodGet, "/v1/comments?sort=id", token, "").decode(t, &page)
+		ts.do(t, http.MethodGet, "/v1/comments?sort=id&cursor=", token, "").decode(t, &cursor)
+		if len(page.Comments) == 0 || len(cursor.Comments) == 0 {
+			t.Fatal("got no comments in the listings")
+		}
+		got["page"] = page.Comments[0].ReplyCount
+		got["cursor"] = cursor.Comments[0].ReplyCount
+
+		// The thread stops above the nested reply, so only the count tells of it
+		var thread struct {
+			Thread data.Comment `json:"thread"`
+		}
+		ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d/thread?max_depth=1", root.ID), token, "").decode(t, &thread)
+		got["thread"] = thread.Thread.ReplyCount
+		for _, r := range thread.Thread.Replies {
+			if r.ID == approved {
+				got["truncated reply"] = r.ReplyCount
+			}
+		}
+		return got
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		wantRoot  int
+		wantReply int
+	}{
+		{"public", "", 1, 0},
+		{"author of the pending replies", bob, 2, 1},
+		{"moderator", moderator, 2, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := map[string]int{"display": tt.wantRoot, "page": tt.wantRoot, "cursor": tt.wantRoot, "thread": tt.wantRoot, "truncated reply": tt.wantReply}
+			if got := counts(tt.token); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("got reply counts %v, want %v", got, want)
+			}
+		})
+	}
+}
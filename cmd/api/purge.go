@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// purgeTrash permanently removes comments that have been in the trash for
+// longer than the configured retention period, checking every interval until
+// ctx is cancelled. The returned channel is closed once it has stopped.
+func (a *applicationDependencies) purgeTrash(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(a.config.trash.purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := a.commentModel.PurgeDeleted(ctx, a.config.trash.retention)
+				if err != nil {
+					a.logger.Error(err.Error())
+					continue
+				}
+				if purged > 0 {
+					a.logger.Info("purged deleted comments", "count", purged)
+				}
+			}
+		}
+	}()
+
+	return done
+}
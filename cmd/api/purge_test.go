@@ -0,0 +1,116 @@
+// Filename: cmd/api/purge_test.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"victortillett.net/basic/internal/data"
+)
+
+// purgeAll is a retention that makes every tombstone old enough to purge.
+// Deletion times are stored to the second, so it looks a second ahead.
+const purgeAll = -time.Second
+
+func TestPurgeDeleted(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+
+	lonely := ts.createTestComment(t, writer, "no replies")
+	parent := ts.createTestComment(t, writer, "has a reply")
+	res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/comments/%d/replies", parent.ID), writer, `{"content": "a reply"}`)
+	var reply commentResponse
+	res.decode(t, &reply)
+	live := ts.createTestComment(t, writer, "never deleted")
+
+	for _, id := range []int64{lonely.ID, parent.ID} {
+		ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", id), writer, "")
+	}
+
+	purge := func(olderThan time.Duration, want int64) {
+		t.Helper()
+		purged, err := app.commentModel.PurgeDeleted(context.Background(), olderThan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged != want {
+			t.Errorf("got %d comments purged, want %d", purged, want)
+		}
+	}
+	inTrash := func(id int64) bool {
+		t.Helper()
+		res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/comments/%d/restore", id), moderator, "")
+		if res.status == http.StatusOK {
+			ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", id), writer, "")
+		}
+		return res.status == http.StatusOK
+	}
+
+	// Nothing has been in the trash for an hour yet
+	purge(time.Hour, 0)
+
+	// The tombstone with a reply stays so the thread below it survives
+	purge(purgeAll, 1)
+	if inTrash(lonely.ID) {
+		t.Error("comment without replies was not purged")
+	}
+	if !inTrash(parent.ID) {
+		t.Error("comment with a reply was purged")
+	}
+
+	// Once its reply has been purged too, the parent goes on the next run
+	ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", reply.Comment.ID), writer, "")
+	purge(purgeAll, 1)
+	purge(purgeAll, 1)
+	if inTrash(parent.ID) {
+		t.Error("comment whose replies were purged was kept")
+	}
+
+	res = ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d", live.ID), "", "")
+	if res.status != http.StatusOK {
+		t.Errorf("got status %d for a live comment after purging, want %d", res.status, http.StatusOK)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.trash.retention = purgeAll
+	app.config.trash.purgeInterval = time.Millisecond
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	comment := ts.createTestComment(t, writer, "soon gone")
+	ts.do(t, http.MethodDelete, fmt.Sprintf("/v1/comments/%d", comment.ID), writer, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := app.purgeTrash(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, metadata, err := app.commentModel.GetDeleted(context.Background(), data.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafelist: []string{"id"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metadata.TotalRecords == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("deleted comment was not purged within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purgeTrash did not stop after its context was cancelled")
+	}
+}
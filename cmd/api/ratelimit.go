@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimiter hands out one token bucket per client key. Buckets hold up to
+// burst tokens and refill at rps tokens per second.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rps     float64
+	burst   int
+	clients map[string]*bucket
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// limitResult describes the state of a client's bucket after a request
+type limitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next request would be allowed
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rps:     rps,
+		burst:   burst,
+		clients: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token from key's bucket if one is available
+func (l *rateLimiter) allow(key string, now time.Time) limitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, found := l.clients[key]
+	if !found {
+		b = &bucket{tokens: float64(l.burst), lastSeen: now}
+		l.clients[key] = b
+	}
+
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.tokens = math.Min(float64(l.burst), b.tokens+elapsed*l.rps)
+	b.lastSeen = now
+
+	result := limitResult{limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = l.durationFor(1 - b.tokens)
+	}
+	result.remaining = int(b.tokens)
+	result.reset = l.durationFor(float64(l.burst) - b.tokens)
+	return result
+}
+
+// durationFor returns how long it takes to refill n tokens
+func (l *rateLimiter) durationFor(n float64) time.Duration {
+	return time.Duration(n / l.rps * float64(time.Second))
+}
+
+// evict forgets clients that have not been seen since before cutoff
+func (l *rateLimiter) evict(cutoff time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.clients {
+		if b.lastSeen.Before(cutoff) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// evictStale runs evict in the background every interval, dropping clients
+// idle for longer than maxIdle, until ctx is cancelled. The returned channel
+// is closed once it has stopped.
+func (l *rateLimiter) evictStale(ctx context.Context, interval, maxIdle time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				l.evict(now.Add(-maxIdle))
+			}
+		}
+	}()
+
+	return done
+}
+
+// parseTrustedProxies turns a list of IP addresses and CIDR ranges into networks
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		// A bare address is a network of one
+		if !strings.Contains(value, "/") {
+			if strings.Contains(value, ":") {
+				value += "/128"
+			} else {
+				value += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that made the request. When the
+// connection comes from a trusted proxy, X-Forwarded-For is walked from the
+// right and the first address not belonging to a trusted proxy wins.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote := net.ParseIP(host)
+	if remote == nil || !isTrustedProxy(remote, trustedProxies) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			break
+		}
+		if !isTrustedProxy(hop, trustedProxies) {
+			return hop.String()
+		}
+	}
+	return host
+}
@@ -0,0 +1,293 @@
+// Filename: cmd/api/ratelimit_test.go
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"victortillett.net/basic/internal/data"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	limiter := newRateLimiter(2, 3)
+	start := time.Now()
+
+	steps := []struct {
+		name           string
+		key            string
+		after          time.Duration
+		wantAllowed    bool
+		wantRemaining  int
+		wantRetryAfter time.Duration
+		wantReset      time.Duration
+	}{
+		{"first", "a", 0, true, 2, 0, 500 * time.Millisecond},
+		{"second", "a", 0, true, 1, 0, time.Second},
+		{"burst used up", "a", 0, true, 0, 0, 1500 * time.Millisecond},
+		{"empty bucket", "a", 0, false, 0, 500 * time.Millisecond, 1500 * time.Millisecond},
+		{"other client", "b", 0, true, 2, 0, 500 * time.Millisecond},
+		{"half refilled", "a", 250 * time.Millisecond, false, 0, 250 * time.Millisecond, 1250 * time.Millisecond},
+		{"refilled", "a", 500 * time.Millisecond, true, 0, 0, 1500 * time.Millisecond},
+		{"refill stops at burst", "a", time.Minute, true, 2, 0, 500 * time.Millisecond},
+	}
+
+	for _, step := range steps {
+		got := limiter.allow(step.key, start.Add(step.after))
+		want := limitResult{
+			allowed:    step.wantAllowed,
+			limit:      3,
+			remaining:  step.wantRemaining,
+			reset:      step.wantReset,
+			retryAfter: step.wantRetryAfter,
+		}
+		if got != want {
+			t.Errorf("%s: got %+v, want %+v", step.name, got, want)
+		}
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	limiter := newRateLimiter(1, 1)
+	start := time.Now()
+	limiter.allow("idle", start)
+	limiter.allow("active", start.Add(2*time.Minute))
+
+	limiter.evict(start.Add(time.Minute))
+	if _, found := limiter.clients["idle"]; found {
+		t.Error("idle client was not evicted")
+	}
+	if _, found := limiter.clients["active"]; !found {
+		t.Error("active client was evicted")
+	}
+
+	t.Run("background", func(t *testing.T) {
+		limiter := newRateLimiter(1, 1)
+		limiter.allow("idle", time.Now())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := limiter.evictStale(ctx, time.Millisecond, 0)
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			limiter.mu.Lock()
+			remaining := len(limiter.clients)
+			limiter.mu.Unlock()
+			if remaining == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d clients after a second, want them evicted", remaining)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("evictStale did not stop after its context was cancelled")
+		}
+	})
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.1/32", "::1/128"}
+	if len(networks) != len(want) {
+		t.Fatalf("got %d networks, want %d", len(networks), len(want))
+	}
+	for i, network := range networks {
+		if network.String() != want[i] {
+			t.Errorf("got network %s, want %s", network, want[i])
+		}
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "proxy.example", ""} {
+		_, err := parseTrustedProxies([]string{value})
+		if err == nil {
+			t.Errorf("got no error for %q", value)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   []string
+		trustedProxies []*net.IPNet
+		want           string
+	}{
+		{"direct client", "203.0.113.5:1234", nil, trusted, "203.0.113.5"},
+		{"spoofed header from a client", "203.0.113.5:1234", []string{"198.51.100.7"}, trusted, "203.0.113.5"},
+		{"no trusted proxies", "10.0.0.2:1234", []string{"198.51.100.7"}, nil, "10.0.0.2"},
+		{"one proxy", "10.0.0.2:1234", []string{"198.51.100.7"}, trusted, "198.51.100.7"},
+		{"chain of proxies", "10.0.0.2:1234", []string{"198.51.100.7, 10.0.0.3"}, trusted, "198.51.100.7"},
+		{"spoofed hop before the client", "10.0.0.2:1234", []string{"1.2.3.4, 198.51.100.7, 10.0.0.3"}, trusted, "198.51.100.7"},
+		{"several headers", "10.0.0.2:1234", []string{"1.2.3.4", "198.51.100.7"}, trusted, "198.51.100.7"},
+		{"only proxies", "10.0.0.2:1234", []string{"10.0.0.3"}, trusted, "10.0.0.2"},
+		{"unparsable hop", "10.0.0.2:1234", []string{"198.51.100.7, not-an-ip"}, trusted, "10.0.0.2"},
+		{"proxy without header", "10.0.0.2:1234", nil, trusted, "10.0.0.2"},
+		{"single trusted address", "192.168.1.1:1234", []string{"198.51.100.7"}, trusted, "198.51.100.7"},
+		{"neighbour of a trusted address", "192.168.1.2:1234", []string{"198.51.100.7"}, trusted, "192.168.1.2"},
+		{"IPv6 proxy", "[::1]:1234", []string{"2001:db8::1"}, trusted, "2001:db8::1"},
+		{"address without port", "203.0.113.5", nil, trusted, "203.0.113.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := clientIP(r, tt.trustedProxies); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.limiters.global = newRateLimiter(0.01, 2)
+	app.limiters.write = newRateLimiter(0.01, 2)
+	app.limiters.auth = newRateLimiter(0.01, 2)
+	ts := newTestServer(t, app.routes())
+
+	wantHeaders := []struct {
+		limit, remaining, reset string
+	}{
+		{"2", "1", "100"},
+		{"2", "0", "200"},
+	}
+	for i, want := range wantHeaders {
+		res := ts.do(t, http.MethodGet, "/v1/comments", "", "")
+		if res.status != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i+1, res.status, http.StatusOK)
+		}
+		got := [3]string{res.header.Get("RateLimit-Limit"), res.header.Get("RateLimit-Remaining"), res.header.Get("RateLimit-Reset")}
+		if got != [3]string{want.limit, want.remaining, want.reset} {
+			t.Errorf("request %d: got RateLimit-Limit, -Remaining, -Reset %q, want %v", i+1, got, want)
+		}
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/comments", "", "")
+	if res.status != http.StatusTooManyRequests {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusTooManyRequests)
+	}
+	var got struct {
+		Error string `json:"error"`
+	}
+	res.decode(t, &got)
+	if got.Error != "rate limit exceeded" {
+		t.Errorf("got error %q, want %q", got.Error, "rate limit exceeded")
+	}
+	if retryAfter := res.header.Get("Retry-After"); retryAfter != "100" {
+		t.Errorf("got Retry-After %q, want %q", retryAfter, "100")
+	}
+	if remaining := res.header.Get("RateLimit-Remaining"); remaining != "0" {
+		t.Errorf("got RateLimit-Remaining %q, want %q", remaining, "0")
+	}
+}
+
+func TestRateLimitTrustedProxy(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.config.limiter.trustedProxies, _ = parseTrustedProxies([]string{"127.0.0.1", "::1"})
+	app.limiters.global = newRateLimiter(0.01, 1)
+	ts := newTestServer(t, app.routes())
+
+	steps := []struct {
+		forwardedFor string
+		wantStatus   int
+	}{
+		{"198.51.100.1", http.StatusOK},
+		{"198.51.100.1", http.StatusTooManyRequests},
+		{"198.51.100.2", http.StatusOK},
+		// A client cannot get a fresh bucket by prepending addresses
+		{"203.0.113.9, 198.51.100.2", http.StatusTooManyRequests},
+	}
+
+	for _, step := range steps {
+		res := ts.do(t, http.MethodGet, "/v1/comments", "", "", "X-Forwarded-For", step.forwardedFor)
+		if res.status != step.wantStatus {
+			t.Errorf("X-Forwarded-For %q: got status %d, want %d", step.forwardedFor, res.status, step.wantStatus)
+		}
+	}
+}
+
+func TestRateLimitRoute(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.limiters.global = newRateLimiter(100, 100)
+	app.limiters.write = newRateLimiter(0.01, 1)
+	app.limiters.auth = newRateLimiter(100, 100)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob", data.PermissionCommentsWrite)
+
+	// Users share an address but each has a write budget of their own
+	steps := []struct {
+		token      string
+		wantStatus int
+	}{
+		{alice, http.StatusCreated},
+		{alice, http.StatusTooManyRequests},
+		{bob, http.StatusCreated},
+	}
+
+	for i, step := range steps {
+		res := ts.do(t, http.MethodPost, "/v1/comments", step.token, `{"content": "hello"}`)
+		if res.status != step.wantStatus {
+			t.Errorf("step %d: got status %d, want %d: %s", i+1, res.status, step.wantStatus, res.body)
+		}
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/comments", alice, "")
+	if res.status != http.StatusOK {
+		t.Errorf("got status %d reading after the write budget ran out, want %d", res.status, http.StatusOK)
+	}
+}
+
+func TestRateLimitSkipsProbes(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.limiter.enabled = true
+	app.limiters.global = newRateLimiter(0.01, 1)
+	ts := newTestServer(t, app.routes())
+
+	res := ts.do(t, http.MethodGet, "/v1/comments", "", "")
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d, want %d", res.status, http.StatusOK)
+	}
+
+	for _, path := range []string{"/v1/healthcheck/live", "/v1/healthcheck/ready", "/health"} {
+		for i := 0; i < 3; i++ {
+			res := ts.do(t, http.MethodGet, path, "", "")
+			if res.status == http.StatusTooManyRequests || res.header.Get("RateLimit-Limit") != "" {
+				t.Fatalf("%s request %d: got status %d with RateLimit-Limit %q, want it not rate limited", path, i+1, res.status, res.header.Get("RateLimit-Limit"))
+			}
+		}
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/healthcheck", "", "")
+	if res.status != http.StatusTooManyRequests {
+		t.Errorf("got status %d for the healthcheck, want %d", res.status, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
+)
+
+// addReactionHandler reacts to a comment as the current user. Reacting twice
+// with the same emoji leaves a single reaction.
+func (a *applicationDependencies) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeReaction(w, r, a.reactionModel.Add)
+}
+
+// removeReactionHandler takes back the current user's reaction. Removing a
+// reaction that was never made succeeds too.
+func (a *applicationDependencies) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	a.changeReaction(w, r, a.reactionModel.Remove)
+}
+
+// changeReaction applies change to the reaction named in the URL and
+// responds with the comment's updated reactions
+func (a *applicationDependencies) changeReaction(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, commentID, userID int64, emoji string) error) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	emoji := httprouter.ParamsFromContext(r.Context()).ByName("emoji")
+	v := validator.New()
+	v.Check(validator.PermittedValue(emoji, a.config.reactions.allowed...), "emoji", "must be one of "+strings.Join(a.config.reactions.allowed, " "))
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, viewer)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !viewer.CanSee(comment) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = change(r.Context(), comment.ID, viewer.UserID, emoji)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.loadReactions(r, comment)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"reactions": comment.Reactions}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// loadReactions fills in the reaction counts of comments, flagging the
+// reactions of the current user
+func (a *applicationDependencies) loadReactions(r *http.Request, comments ...*data.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.ID
+	}
+
+	summary, err := a.reactionModel.Summarize(r.Context(), ids, a.contextGetUser(r).ID)
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		comment.Reactions = summary[comment.ID]
+		if comment.Reactions == nil {
+			comment.Reactions = []data.ReactionCount{}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+// Filename: cmd/api/reactions_test.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"victortillett.net/basic/internal/data"
+)
+
+type reactionsResponse struct {
+	Reactions []data.ReactionCount `json:"reactions"`
+}
+
+func reactionPath(commentID int64, emoji string) string {
+	return fmt.Sprintf("/v1/comments/%d/reactions/%s", commentID, url.PathEscape(emoji))
+}
+
+func TestChangeReaction(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	comment := ts.createTestComment(t, alice, "react to me")
+
+	steps := []struct {
+		name          string
+		method        string
+		token         string
+		emoji         string
+		wantReactions []data.ReactionCount
+	}{
+		{"first reaction", http.MethodPut, alice, "👍", []data.ReactionCount{{Emoji: "👍", Count: 1, ReactedByMe: true}}},
+		{"repeated reaction", http.MethodPut, alice, "👍", []data.ReactionCount{{Emoji: "👍", Count: 1, ReactedByMe: true}}},
+		{"another user", http.MethodPut, bob, "👍", []data.ReactionCount{{Emoji: "👍", Count: 2, ReactedByMe: true}}},
+		{"another emoji", http.MethodPut, bob, "🎉", []data.ReactionCount{{Emoji: "👍", Count: 2, ReactedByMe: true}, {Emoji: "🎉", Count: 1, ReactedByMe: true}}},
+		{"removed", http.MethodDelete, alice, "👍", []data.ReactionCount{{Emoji: "🎉", Count: 1}, {Emoji: "👍", Count: 1}}},
+		{"removed again", http.MethodDelete, alice, "👍", []data.ReactionCount{{Emoji: "🎉", Count: 1}, {Emoji: "👍", Count: 1}}},
+	}
+
+	for _, step := range steps {
+		res := ts.do(t, step.method, reactionPath(comment.ID, step.emoji), step.token, "")
+		if res.status != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, res.status, http.StatusOK, res.body)
+		}
+
+		var got reactionsResponse
+		res.decode(t, &got)
+		if fmt.Sprint(got.Reactions) != fmt.Sprint(step.wantReactions) {
+			t.Errorf("%s: got reactions %v, want %v", step.name, got.Reactions, step.wantReactions)
+		}
+	}
+}
+
+func TestChangeReactionErrors(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	comment := ts.createTestComment(t, alice, "react to me")
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"anonymous", reactionPath(comment.ID, "👍"), "", http.StatusUnauthorized},
+		{"emoji not allowed", reactionPath(comment.ID, "🐍"), alice, http.StatusUnprocessableEntity},
+		{"text instead of emoji", reactionPath(comment.ID, "like"), alice, http.StatusUnprocessableEntity},
+		{"missing comment", reactionPath(999, "👍"), alice, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPut, tt.path, tt.token, "")
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+}
+
+func TestReactToPendingComment(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.moderation.premoderate = true
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+	pending := createTargetComment(t, ts, alice, "wait for me", "articles", "1")
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+	}{
+		{"reaction by another user", http.MethodPut, bob, http.StatusNotFound},
+		{"removal by another user", http.MethodDelete, bob, http.StatusNotFound},
+		{"reaction by the author", http.MethodPut, alice, http.StatusOK},
+		{"reaction by a moderator", http.MethodPut, moderator, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, tt.method, reactionPath(pending.ID, "👍"), tt.token, "")
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+}
+
+func TestCommentsShowReactions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	liked := ts.createTestComment(t, alice, "liked")
+	ignored := ts.createTestComment(t, alice, "ignored")
+	path := fmt.Sprintf("/v1/comments/%d", liked.ID)
+
+	before := ts.do(t, http.MethodGet, path, "", "")
+	etag := before.header.Get("ETag")
+
+	res := ts.do(t, http.MethodPut, reactionPath(liked.ID, "❤️"), bob, "")
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d reacting: %s", res.status, res.body)
+	}
+
+	t.Run("display", func(t *testing.T) {
+		for _, viewer := range []struct {
+			name        string
+			token       string
+			reactedByMe bool
+		}{
+			{"reactor", bob, true},
+			{"other user", alice, false},
+			{"anonymous", "", false},
+		} {
+			res := ts.do(t, http.MethodGet, path, viewer.token, "")
+			var got commentResponse
+			res.decode(t, &got)
+			want := []data.ReactionCount{{Emoji: "❤️", Count: 1, ReactedByMe: viewer.reactedByMe}}
+			if fmt.Sprint(got.Comment.Reactions) != fmt.Sprint(want) {
+				t.Errorf("%s: got reactions %v, want %v", viewer.name, got.Comment.Reactions, want)
+			}
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		for _, query := range []string{"", "?cursor="} {
+			res := ts.do(t, http.MethodGet, "/v1/comments"+query, bob, "")
+			var got commentListResponse
+			res.decode(t, &got)
+			if len(got.Comments) != 2 {
+				t.Fatalf("got %d comments, want 2", len(got.Comments))
+			}
+			for _, comment := range got.Comments {
+				wantCount := 1
+				if comment.ID == ignored.ID {
+					wantCount = 0
+				}
+				if len(comment.Reactions) != wantCount {
+					t.Errorf("list%s: got reactions %v on comment %d", query, comment.Reactions, comment.ID)
+				}
+			}
+		}
+	})
+
+	t.Run("conditional requests", func(t *testing.T) {
+		res := ts.do(t, http.MethodGet, path, bob, "", "If-None-Match", etag)
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d for an ETag from before the reaction, want %d", res.status, http.StatusOK)
+		}
+		if res.header.Get("ETag") == etag {
+			t.Errorf("reacting did not change the ETag %s", etag)
+		}
+		if vary := res.header.Values("Vary"); len(vary) == 0 || vary[len(vary)-1] != "Authorization" {
+			t.Errorf("got Vary %v, want it to include Authorization", vary)
+		}
+
+		// An edit only conflicts with edits, not with reactions
+		res = ts.do(t, http.MethodPatch, path, alice, `{"content": "edited"}`, "If-Match", res.header.Get("ETag"))
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d updating with the ETag of a comment with reactions: %s", res.status, res.body)
+		}
+
+		// The edit's ETag covers the reactions, just like a GET's
+		res = ts.do(t, http.MethodGet, path, alice, "", "If-None-Match", res.header.Get("ETag"))
+		if res.status != http.StatusNotModified {
+			t.Errorf("got status %d revalidating with the ETag from the edit, want %d", res.status, http.StatusNotModified)
+		}
+	})
+}
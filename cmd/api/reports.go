@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
+)
+
+// createReportHandler flags a comment as abusive on behalf of the current
+// user. Reporting the same comment again replaces the earlier report.
+func (a *applicationDependencies) createReportHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, viewer)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !viewer.CanSee(comment) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	report := &data.Report{
+		CommentID:  comment.ID,
+		ReporterID: a.contextGetUser(r).ID,
+		Reason:     incomingData.Reason,
+		Details:    incomingData.Details,
+	}
+
+	v := validator.New()
+	data.ValidateReport(v, report)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	created, err := a.reportStore(r).Add(r.Context(), report, a.config.reports.hideThreshold)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	err = a.writeJSON(w, status, envelope{"report": report}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// listReportsHandler lists the comments with open reports for moderators,
+// most reported first
+func (a *applicationDependencies) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	v := validator.New()
+	filters := data.Filters{
+		Page:         a.readInt(query, "page", 1, v),
+		PageSize:     a.readInt(query, "page_size", 10, v),
+		Sort:         query.Get("sort"),
+		SortSafelist: []string{"id", "reports", "latest", "-id", "-reports", "-latest"},
+	}
+	if filters.Sort == "" {
+		filters.Sort = "-reports"
+	}
+	data.ValidateFilters(v, filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	summaries, metadata, err := a.reportStore(r).Summarize(r.Context(), filters)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	dataResponse := envelope{
+		"reports":  summaries,
+		"metadata": metadata,
+	}
+	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,123 @@
+// Filename: cmd/api/reports_test.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"victortillett.net/basic/internal/data"
+)
+
+type reportListResponse struct {
+	Reports []data.ReportSummary `json:"reports"`
+}
+
+func reportPath(commentID int64) string {
+	return fmt.Sprintf("/v1/comments/%d/reports", commentID)
+}
+
+func TestCreateReport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	comment := ts.createTestComment(t, alice, "report me")
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"anonymous", reportPath(comment.ID), "", `{"reason": "spam"}`, http.StatusUnauthorized},
+		{"unknown reason", reportPath(comment.ID), bob, `{"reason": "boring"}`, http.StatusUnprocessableEntity},
+		{"other without details", reportPath(comment.ID), bob, `{"reason": "other"}`, http.StatusUnprocessableEntity},
+		{"missing comment", reportPath(999), bob, `{"reason": "spam"}`, http.StatusNotFound},
+		{"first report", reportPath(comment.ID), bob, `{"reason": "spam"}`, http.StatusCreated},
+		{"repeated report", reportPath(comment.ID), bob, `{"reason": "other", "details": "it is an ad"}`, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, tt.path, tt.token, tt.body)
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+}
+
+func TestReportsHideComment(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.reports.hideThreshold = 2
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	dave := createTestUser(t, app, "Dave")
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+	comment := ts.createTestComment(t, alice, "rude words")
+	path := fmt.Sprintf("/v1/comments/%d", comment.ID)
+
+	publicCount := func() int {
+		t.Helper()
+		var got commentListResponse
+		ts.do(t, http.MethodGet, "/v1/comments", "", "").decode(t, &got)
+		return len(got.Comments)
+	}
+
+	// One reporter reporting twice is still one reporter
+	ts.do(t, http.MethodPost, reportPath(comment.ID), bob, `{"reason": "abuse"}`)
+	ts.do(t, http.MethodPost, reportPath(comment.ID), bob, `{"reason": "harassment"}`)
+	if res := ts.do(t, http.MethodGet, path, "", ""); res.status != http.StatusOK {
+		t.Fatalf("got status %d below the threshold, want %d", res.status, http.StatusOK)
+	}
+
+	ts.do(t, http.MethodPost, reportPath(comment.ID), dave, `{"reason": "abuse", "details": "insults"}`)
+	if res := ts.do(t, http.MethodGet, path, "", ""); res.status != http.StatusNotFound {
+		t.Errorf("got status %d at the threshold, want %d", res.status, http.StatusNotFound)
+	}
+	if count := publicCount(); count != 0 {
+		t.Errorf("got %d public comments, want the hidden one left out", count)
+	}
+
+	res := ts.do(t, http.MethodGet, path, alice, "")
+	var own commentResponse
+	res.decode(t, &own)
+	if res.status != http.StatusOK || !own.Comment.Hidden {
+		t.Errorf("got status %d and hidden %t for the author, want %d and true", res.status, own.Comment.Hidden, http.StatusOK)
+	}
+
+	res = ts.do(t, http.MethodGet, "/v1/moderation/reports", moderator, "")
+	if res.status != http.StatusOK {
+		t.Fatalf("got status %d listing reports: %s", res.status, res.body)
+	}
+	var got reportListResponse
+	res.decode(t, &got)
+	if len(got.Reports) != 1 {
+		t.Fatalf("got %d reported comments, want 1", len(got.Reports))
+	}
+	summary := got.Reports[0]
+	wantReasons := map[string]int{"abuse": 1, "harassment": 1}
+	if summary.Comment.ID != comment.ID || summary.ReportCount != 2 || fmt.Sprint(summary.Reasons) != fmt.Sprint(wantReasons) || len(summary.Reports) != 2 {
+		t.Errorf("got summary %+v, want 2 reports on comment %d with reasons %v", summary, comment.ID, wantReasons)
+	}
+
+	if res := ts.do(t, http.MethodGet, "/v1/moderation/reports", bob, ""); res.status != http.StatusForbidden {
+		t.Errorf("got status %d listing reports without permission, want %d", res.status, http.StatusForbidden)
+	}
+
+	// Approval overrules the reports
+	ts.do(t, http.MethodPost, path+"/approve", moderator, "")
+	if count := publicCount(); count != 1 {
+		t.Errorf("got %d public comments after approval, want 1", count)
+	}
+	ts.do(t, http.MethodGet, "/v1/moderation/reports", moderator, "").decode(t, &got)
+	if len(got.Reports) != 0 {
+		t.Errorf("got %d reported comments after approval, want none", len(got.Reports))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/diff"
+	"victortillett.net/basic/internal/validator"
+)
+
+func (a *applicationDependencies) listRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	comment, ok := a.readRevisionComment(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := a.commentStore(r).GetRevisions(r.Context(), comment.ID)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"revisions": revisions}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := a.readVersionParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	comment, ok := a.readRevisionComment(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	v := validator.New()
+	diffFrom := a.readInt(query, "diff_from", 0, v)
+	diffMode := query.Get("diff_mode")
+	if diffMode == "" {
+		diffMode = "word"
+	}
+	v.Check(diffFrom >= 0, "diff_from", "must be a version number")
+	v.Check(validator.PermittedValue(diffMode, "word", "line"), "diff_mode", "must be word or line")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revision, err := a.commentStore(r).GetRevision(r.Context(), comment.ID, version)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	dataResponse := envelope{"revision": revision}
+
+	if diffFrom > 0 {
+		from, err := a.commentStore(r).GetRevision(r.Context(), comment.ID, int32(diffFrom))
+		if err != nil {
+			switch {
+			case err == data.ErrRecordNotFound:
+				v.AddError("diff_from", "no such version of this comment")
+				a.failedValidationResponse(w, r, v.Errors)
+			default:
+				a.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		var ops []diff.Op
+		if diffMode == "line" {
+			ops = diff.Lines(from.Content, revision.Content)
+		} else {
+			ops = diff.Words(from.Content, revision.Content)
+		}
+		dataResponse["diff"] = envelope{
+			"from": from.Version,
+			"to":   revision.Version,
+			"mode": diffMode,
+			"ops":  ops,
+		}
+	}
+
+	err = a.writeJSON(w, http.StatusOK, dataResponse, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// readRevisionComment loads the comment named in the URL and checks that the
+// caller may see its history, which is limited to its author and moderators.
+// It sends the error response itself and reports whether to carry on.
+func (a *applicationDependencies) readRevisionComment(w http.ResponseWriter, r *http.Request) (*data.Comment, bool) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, viewer)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !viewer.CanModify(comment) {
+		a.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return comment, true
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
+	"victortillett.net/basic/internal/data"
 )
 
 func (a *applicationDependencies) routes() http.Handler {
@@ -11,15 +12,82 @@ func (a *applicationDependencies) routes() http.Handler {
 	router.NotFound = http.HandlerFunc(a.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(a.methodNotAllowedResponse)
 
+	// Route-specific rate limits are applied before the permission lookup
+	writeLimited := func(next http.HandlerFunc) http.HandlerFunc {
+		return a.rateLimitRoute(a.limiters.write, next)
+	}
+	authLimited := func(next http.HandlerFunc) http.HandlerFunc {
+		return a.rateLimitRoute(a.limiters.auth, next)
+	}
+
+	// handle registers a route under its pattern, which labels its metrics
+	handle := func(method, pattern string, handler http.HandlerFunc) {
+		router.HandlerFunc(method, pattern, a.tagRoute(pattern, handler))
+	}
+
 	// Routes
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/comments", a.createCommentHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/comments/:id", a.displayCommentHandler)
-	router.HandlerFunc("PATCH", "/v1/comments/:id", a.updateCommentHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/comments/:id", a.deleteCommentHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/comments", a.listCommentsHandler)
+	handle(http.MethodGet, "/v1/healthcheck", a.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthcheck/live", a.healthcheckHandler)
+	handle(http.MethodGet, "/v1/healthcheck/ready", a.readinessHandler)
+	// Kept for probes configured against the old standalone server
+	handle(http.MethodGet, "/health", a.healthcheckHandler)
+	handle(http.MethodPost, "/v1/comments", writeLimited(a.requirePermission(data.PermissionCommentsWrite, a.createCommentHandler)))
+	// httprouter cannot register /v1/comments/trash next to /v1/comments/:id,
+	// so the trash is served from the :id route
+	listTrash := a.tagRoute("/v1/comments/trash", a.requirePermission(data.PermissionCommentsModerate, a.listDeletedCommentsHandler))
+	handle(http.MethodGet, "/v1/comments/:id", func(w http.ResponseWriter, r *http.Request) {
+		if httprouter.ParamsFromContext(r.Context()).ByName("id") == "trash" {
+			listTrash(w, r)
+			return
+		}
+		a.displayCommentHandler(w, r)
+	})
+	handle("PATCH", "/v1/comments/:id", writeLimited(a.requirePermission(data.PermissionCommentsWrite, a.updateCommentHandler)))
+	handle(http.MethodDelete, "/v1/comments/:id", writeLimited(a.requirePermission(data.PermissionCommentsWrite, a.deleteCommentHandler)))
+	handle(http.MethodGet, "/v1/comments", a.listCommentsHandler)
+	handle(http.MethodPost, "/v1/comments/:id/replies", writeLimited(a.requirePermission(data.PermissionCommentsWrite, a.createReplyHandler)))
+	handle(http.MethodGet, "/v1/comments/:id/thread", a.displayThreadHandler)
+	handle(http.MethodGet, "/v1/comments/:id/revisions", a.requireActivatedUser(a.listRevisionsHandler))
+	handle(http.MethodGet, "/v1/comments/:id/revisions/:version", a.requireActivatedUser(a.displayRevisionHandler))
+	handle(http.MethodPut, "/v1/comments/:id/reactions/:emoji", writeLimited(a.requireActivatedUser(a.addReactionHandler)))
+	handle(http.MethodDelete, "/v1/comments/:id/reactions/:emoji", writeLimited(a.requireActivatedUser(a.removeReactionHandler)))
+	handle(http.MethodPut, "/v1/comments/:id/vote", writeLimited(a.requireActivatedUser(a.voteHandler)))
+	handle(http.MethodDelete, "/v1/comments/:id/vote", writeLimited(a.requireActivatedUser(a.withdrawVoteHandler)))
+	handle(http.MethodPost, "/v1/comments/:id/reports", writeLimited(a.requireActivatedUser(a.createReportHandler)))
+	handle(http.MethodPost, "/v1/comments/:id/restore", a.requirePermission(data.PermissionCommentsModerate, a.restoreCommentHandler))
+	handle(http.MethodPost, "/v1/comments/:id/approve", a.requirePermission(data.PermissionCommentsModerate, a.approveCommentHandler))
+	handle(http.MethodPost, "/v1/comments/:id/reject", a.requirePermission(data.PermissionCommentsModerate, a.rejectCommentHandler))
+
+	handle(http.MethodGet, "/v1/moderation/queue", a.requirePermission(data.PermissionCommentsModerate, a.listModerationQueueHandler))
+	handle(http.MethodPost, "/v1/moderation/bulk", a.requirePermission(data.PermissionCommentsModerate, a.bulkModerateHandler))
+	handle(http.MethodGet, "/v1/moderation/reports", a.requirePermission(data.PermissionCommentsModerate, a.listReportsHandler))
+
+	handle(http.MethodGet, "/v1/targets/:ns/:id/comments", a.listTargetCommentsHandler)
+	handle(http.MethodGet, "/v1/namespaces/:ns", a.displayNamespaceHandler)
+	handle(http.MethodPut, "/v1/namespaces/:ns", a.requirePermission(data.PermissionCommentsModerate, a.updateNamespaceHandler))
+	handle(http.MethodGet, "/v1/namespaces/:ns/counts", a.countTargetCommentsHandler)
+
+	handle(http.MethodPost, "/v1/users", authLimited(a.registerUserHandler))
+	handle(http.MethodPut, "/v1/users/activated", authLimited(a.activateUserHandler))
+	handle(http.MethodPut, "/v1/users/password", authLimited(a.updateUserPasswordHandler))
+
+	handle(http.MethodPost, "/v1/tokens/authentication", authLimited(a.createAuthenticationTokenHandler))
+	handle(http.MethodPost, "/v1/tokens/password-reset", authLimited(a.createPasswordResetTokenHandler))
+
+	// CORS, rate limiting and bearer token authentication
+	var api http.Handler = a.enableCORS(a.rateLimit(a.authenticate(router)))
 
+	// Without a listen address of their own, metrics are served here behind
+	// basic authentication, which the bearer token check must not see
+	if a.config.metrics.addr == "" && a.config.metrics.username != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/", api)
+		mux.HandleFunc("GET /metrics", a.tagRoute("/metrics", a.requireMetricsAuth(a.metricsHandler)))
+		api = mux
+	}
 
-	// Wrap in CORS
-	return a.enableCORS(router)
+	// Tag, log and measure the request, then recover panics. Panics are
+	// recovered inside the access log and metrics so that their 500
+	// responses are counted too.
+	return a.requestID(a.logRequests(a.collectMetrics(a.recoverPanic(api))))
 }
@@ -2,13 +2,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-// serve starts the HTTP server with configured settings.
+// serve starts the HTTP server with configured settings and blocks until it
+// has been shut down.
 func (app *applicationDependencies) serve() error {
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.config.port),                      // Server address
@@ -19,7 +26,85 @@ func (app *applicationDependencies) serve() error {
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError), // Error logger
 	}
 
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+
+	// The metrics listener stays up until the main server has finished
+	// shutting down, so the drain itself can be observed
+	if app.config.metrics.addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /metrics", app.metricsHandler)
+		metricsSrv := &http.Server{
+			Addr:        app.config.metrics.addr,
+			Handler:     mux,
+			ReadTimeout: 5 * time.Second,
+			ErrorLog:    slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		}
+		metricsListener, err := net.Listen("tcp", metricsSrv.Addr)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer metricsSrv.Close()
+
+		app.logger.Info("starting metrics server", "addr", metricsListener.Addr().String())
+		go metricsSrv.Serve(metricsListener)
+	}
+
+	return app.serveOn(srv, listener)
+}
+
+// serveOn runs srv on listener until the process receives SIGINT or SIGTERM.
+// In-flight requests are then given the configured grace period to finish
+// and background goroutines are waited for before it returns.
+func (app *applicationDependencies) serveOn(srv *http.Server, listener net.Listener) error {
+	shutdownError := make(chan error)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(quit)
+		s := <-quit
+
+		// Fail readiness first so load balancers stop sending new requests
+		app.shuttingDown.Store(true)
+		if app.config.shutdownDelay > 0 {
+			app.logger.Info("draining before shutdown", "signal", s.String(), "delay", app.config.shutdownDelay.String())
+			time.Sleep(app.config.shutdownDelay)
+		}
+
+		app.logger.Info("shutting down server", "signal", s.String(), "grace_period", app.config.shutdownTimeout.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		app.logger.Info("completing background tasks")
+		app.wg.Wait()
+		shutdownError <- nil
+	}()
+
 	// Log server startup information.
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.environment)
-	return srv.ListenAndServe() // Start listening for HTTP requests.
-}
\ No newline at end of file
+	app.logger.Info("starting server", "addr", listener.Addr().String(), "env", app.config.environment)
+
+	err := srv.Serve(listener) // Start accepting HTTP requests.
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", listener.Addr().String())
+	return nil
+}
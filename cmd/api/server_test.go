@@ -0,0 +1,102 @@
+// Filename: cmd/api/server_test.go
+
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServeCompletesInFlightRequestsOnShutdown(t *testing.T) {
+	app := &applicationDependencies{
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	app.config.shutdownTimeout = 5 * time.Second
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	backgroundDone := false
+	app.background(func() {
+		<-started
+		time.Sleep(300 * time.Millisecond)
+		backgroundDone = true
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: handler}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- app.serveOn(srv, listener)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	responses := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String() + "/")
+		if err != nil {
+			responses <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		responses <- result{body: string(body), err: err}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the handler")
+	}
+
+	err = syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case res := <-responses:
+		if res.err != nil {
+			t.Fatalf("in-flight request failed: %v", res.err)
+		}
+		if res.body != "done" {
+			t.Errorf("expected body %q, got %q", "done", res.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request did not complete")
+	}
+
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Fatalf("serveOn returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	if !backgroundDone {
+		t.Error("serveOn returned before background tasks finished")
+	}
+
+	_, err = net.DialTimeout("tcp", listener.Addr().String(), time.Second)
+	if err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
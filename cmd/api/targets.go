@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
+)
+
+// listTargetCommentsHandler lists the comments on one target. It takes the
+// same query parameters as listCommentsHandler.
+func (a *applicationDependencies) listTargetCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	target, err := a.readTargetParams(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+	a.listComments(w, r, target)
+}
+
+// countTargetCommentsHandler responds with the number of comments on each
+// target in a namespace whose id is given as an id query parameter, so a
+// page listing many resources can show all their counts with one request
+func (a *applicationDependencies) countTargetCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	namespace, err := a.readNamespaceParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	ids := r.URL.Query()["id"]
+	v := validator.New()
+	v.Check(len(ids) > 0, "id", "must be provided")
+	v.Check(len(ids) <= 100, "id", "must not be given more than 100 times")
+	for _, id := range ids {
+		v.Check(validator.Matches(id, data.TargetIDRX), "id", "must be 1 to 100 letters, digits or any of . _ : ~ -")
+	}
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	counts, err := a.commentStore(r).CountByTarget(r.Context(), namespace, ids)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"counts": counts}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) displayNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := a.readNamespaceParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	namespace, err := a.namespaceModel.Get(r.Context(), name)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"namespace": namespace}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateNamespaceHandler opens, closes or locks every target in a namespace
+// and turns premoderation of their comments on or off. Settings left out of
+// the request are kept.
+func (a *applicationDependencies) updateNamespaceHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := a.readNamespaceParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	namespace, err := a.namespaceModel.Get(r.Context(), name)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	var incomingData struct {
+		Status      *string `json:"status"`
+		Premoderate *bool   `json:"premoderate"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if incomingData.Status != nil {
+		namespace.Status = *incomingData.Status
+	}
+	if incomingData.Premoderate != nil {
+		namespace.Premoderate = *incomingData.Premoderate
+	}
+
+	v := validator.New()
+	data.ValidateNamespace(v, namespace)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.namespaceModel.Set(r.Context(), namespace)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"namespace": namespace}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
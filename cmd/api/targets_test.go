@@ -0,0 +1,194 @@
+// Filename: cmd/api/targets_test.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"victortillett.net/basic/internal/data"
+)
+
+type namespaceResponse struct {
+	Namespace data.Namespace `json:"namespace"`
+}
+
+func createTargetComment(t *testing.T, ts *testServer, token, content, namespace, id string) data.Comment {
+	t.Helper()
+
+	body := fmt.Sprintf(`{"content": %q, "target": {"namespace": %q, "id": %q}}`, content, namespace, id)
+	res := ts.do(t, http.MethodPost, "/v1/comments", token, body)
+	if res.status != http.StatusCreated {
+		t.Fatalf("got status %d creating comment: %s", res.status, res.body)
+	}
+	var got commentResponse
+	res.decode(t, &got)
+	return got.Comment
+}
+
+func TestCreateCommentOnTarget(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+
+	comment := createTargetComment(t, ts, writer, "nice article", "articles", "how-to-go")
+	want := data.Target{Namespace: "articles", ID: "how-to-go"}
+	if comment.Target != want {
+		t.Errorf("got target %+v, want %+v", comment.Target, want)
+	}
+
+	res := ts.do(t, http.MethodPost, fmt.Sprintf("/v1/comments/%d/replies", comment.ID), writer, `{"content": "agreed"}`)
+	var reply commentResponse
+	res.decode(t, &reply)
+	if reply.Comment.Target != want {
+		t.Errorf("got reply target %+v, want the parent's %+v", reply.Comment.Target, want)
+	}
+
+	untargeted := ts.createTestComment(t, writer, "about nothing")
+	res = ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d", untargeted.ID), "", "")
+	if strings.Contains(res.body, `"target"`) {
+		t.Errorf("got a target on a comment without one: %s", res.body)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"missing id", `{"content": "hi", "target": {"namespace": "articles"}}`},
+		{"missing namespace", `{"content": "hi", "target": {"id": "1"}}`},
+		{"upper-case namespace", `{"content": "hi", "target": {"namespace": "Articles", "id": "1"}}`},
+		{"slash in id", `{"content": "hi", "target": {"namespace": "articles", "id": "a/b"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/comments", writer, tt.body)
+			if res.status != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want %d: %s", res.status, http.StatusUnprocessableEntity, res.body)
+			}
+		})
+	}
+}
+
+func TestListTargetComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	createTargetComment(t, ts, writer, "first on 1", "articles", "1")
+	createTargetComment(t, ts, writer, "first on 2", "articles", "2")
+	createTargetComment(t, ts, writer, "second on 1", "articles", "1")
+	createTargetComment(t, ts, writer, "a ticket", "tickets", "1")
+	ts.createTestComment(t, writer, "about nothing")
+
+	t.Run("list", func(t *testing.T) {
+		for _, query := range []string{"", "?cursor="} {
+			res := ts.do(t, http.MethodGet, "/v1/targets/articles/1/comments"+query, "", "")
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.status, res.body)
+			}
+
+			var got commentListResponse
+			res.decode(t, &got)
+			var content []string
+			for _, comment := range got.Comments {
+				content = append(content, comment.Content)
+			}
+			if want := "first on 1|second on 1"; strings.Join(content, "|") != want {
+				t.Errorf("list%s: got %q, want %q", query, strings.Join(content, "|"), want)
+			}
+		}
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		res := ts.do(t, http.MethodGet, "/v1/targets/Articles/1/comments", "", "")
+		if res.status != http.StatusNotFound {
+			t.Errorf("got status %d, want %d", res.status, http.StatusNotFound)
+		}
+	})
+
+	t.Run("counts", func(t *testing.T) {
+		res := ts.do(t, http.MethodGet, "/v1/namespaces/articles/counts?id=1&id=2&id=3", "", "")
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d: %s", res.status, res.body)
+		}
+
+		var got struct {
+			Counts map[string]int `json:"counts"`
+		}
+		res.decode(t, &got)
+		want := map[string]int{"1": 2, "2": 1, "3": 0}
+		if fmt.Sprint(got.Counts) != fmt.Sprint(want) {
+			t.Errorf("got counts %v, want %v", got.Counts, want)
+		}
+
+		res = ts.do(t, http.MethodGet, "/v1/namespaces/articles/counts", "", "")
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d without ids, want %d", res.status, http.StatusUnprocessableEntity)
+		}
+	})
+}
+
+func TestNamespaceStatus(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	writer := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+	comment := createTargetComment(t, ts, writer, "before closing", "videos", "intro")
+
+	setStatus := func(status string) {
+		t.Helper()
+		res := ts.do(t, http.MethodPut, "/v1/namespaces/videos", moderator, fmt.Sprintf(`{"status": %q}`, status))
+		if res.status != http.StatusOK {
+			t.Fatalf("got status %d setting %s: %s", res.status, status, res.body)
+		}
+	}
+
+	res := ts.do(t, http.MethodGet, "/v1/namespaces/videos", "", "")
+	var got namespaceResponse
+	res.decode(t, &got)
+	if got.Namespace.Status != data.NamespaceOpen {
+		t.Errorf("got status %q for an unconfigured namespace, want %q", got.Namespace.Status, data.NamespaceOpen)
+	}
+
+	newComment := `{"content": "hi", "target": {"namespace": "videos", "id": "intro"}}`
+	reply := fmt.Sprintf("/v1/comments/%d/replies", comment.ID)
+
+	steps := []struct {
+		status     string
+		token      string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{data.NamespaceClosed, writer, http.MethodPost, "/v1/comments", newComment, http.StatusForbidden},
+		{data.NamespaceClosed, writer, http.MethodPost, reply, `{"content": "still here"}`, http.StatusCreated},
+		{data.NamespaceLocked, writer, http.MethodPost, reply, `{"content": "still here?"}`, http.StatusForbidden},
+		{data.NamespaceLocked, moderator, http.MethodPost, "/v1/comments", newComment, http.StatusCreated},
+		{data.NamespaceOpen, writer, http.MethodPost, "/v1/comments", newComment, http.StatusCreated},
+	}
+
+	for _, step := range steps {
+		setStatus(step.status)
+		res := ts.do(t, step.method, step.path, step.token, step.body)
+		if res.status != step.wantStatus {
+			t.Errorf("%s %s while %s: got status %d, want %d: %s", step.method, step.path, step.status, res.status, step.wantStatus, res.body)
+		}
+	}
+
+	t.Run("settings errors", func(t *testing.T) {
+		res := ts.do(t, http.MethodPut, "/v1/namespaces/videos", writer, `{"status": "closed"}`)
+		if res.status != http.StatusForbidden {
+			t.Errorf("got status %d without permission, want %d", res.status, http.StatusForbidden)
+		}
+		res = ts.do(t, http.MethodPut, "/v1/namespaces/videos", moderator, `{"status": "archived"}`)
+		if res.status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d for an unknown status, want %d", res.status, http.StatusUnprocessableEntity)
+		}
+	})
+}
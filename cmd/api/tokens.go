@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
+)
+
+func (a *applicationDependencies) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, incomingData.Email)
+	data.ValidatePasswordPlaintext(v, incomingData.Password)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetByEmail(r.Context(), incomingData.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.invalidCredentialsResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	match, err := user.Password.Matches(incomingData.Password)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		a.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	token, err := a.tokenModel.New(r.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
+
+func (a *applicationDependencies) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateEmail(v, incomingData.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Unknown and inactive accounts get the same response as active ones, so
+	// the endpoint cannot be used to find out which addresses are registered
+	message := "if an activated account uses this address, an email will be sent to it containing password reset instructions"
+
+	user, err := a.userModel.GetByEmail(r.Context(), incomingData.Email)
+	switch {
+	case errors.Is(err, data.ErrRecordNotFound):
+		user = nil
+	case err != nil:
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user != nil && user.Activated {
+		token, err := a.tokenModel.New(r.Context(), user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			a.serverErrorResponse(w, r, err)
+			return
+		}
+
+		logger := a.contextGetLogger(r)
+		a.background(func() {
+			emailData := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+			err := a.mailer.Send(user.Email, "token_password_reset.tmpl", emailData)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		})
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, envelope{"message": message}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
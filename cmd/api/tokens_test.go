@@ -0,0 +1,46 @@
+// Filename: cmd/api/tokens_test.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"victortillett.net/basic/internal/data"
+)
+
+func TestCreatePasswordResetToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	createTestUser(t, app, "Alice")
+
+	inactive := &data.User{Name: "Bob", Email: "bob@example.com"}
+	err := inactive.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.userModel.Insert(context.Background(), inactive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown, inactive and activated accounts must be indistinguishable
+	want := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", `{"email": "nobody@example.com"}`)
+	if want.status != http.StatusAccepted {
+		t.Fatalf("got status %d for an unknown address, want %d: %s", want.status, http.StatusAccepted, want.body)
+	}
+
+	for _, email := range []string{"bob@example.com", "alice@example.com"} {
+		res := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", `{"email": "`+email+`"}`)
+		if res.status != want.status || res.body != want.body {
+			t.Errorf("%s: got status %d %q, want %d %q", email, res.status, res.body, want.status, want.body)
+		}
+	}
+
+	res := ts.do(t, http.MethodPost, "/v1/tokens/password-reset", "", `{"email": "not an email"}`)
+	if res.status != http.StatusUnprocessableEntity {
+		t.Errorf("got status %d for an invalid address, want %d", res.status, http.StatusUnprocessableEntity)
+	}
+}
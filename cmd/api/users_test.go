@@ -0,0 +1,130 @@
+// Filename: cmd/api/users_test.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"victortillett.net/basic/internal/data"
+)
+
+func TestRegisterUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	body := `{"name": "Alice", "email": "alice@example.com", "password": "pa55word1234"}`
+	res := ts.do(t, http.MethodPost, "/v1/users", "", body)
+	if res.status != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d: %s", res.status, http.StatusAccepted, res.body)
+	}
+
+	var got struct {
+		User data.User `json:"user"`
+	}
+	res.decode(t, &got)
+	if got.User.Activated {
+		t.Error("got an activated user, want an inactive one")
+	}
+
+	permissions, err := app.permissionModel.GetAllForUser(context.Background(), got.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !permissions.Include(data.PermissionCommentsWrite) {
+		t.Errorf("got permissions %v, want %s granted on registration", permissions, data.PermissionCommentsWrite)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"duplicate email", `{"name": "Alice", "email": "ALICE@example.com", "password": "pa55word1234"}`},
+		{"short password", `{"name": "Bob", "email": "bob@example.com", "password": "short"}`},
+		{"password too long for bcrypt", fmt.Sprintf(`{"name": "Bob", "email": "bob@example.com", "password": %q}`, strings.Repeat("a", 73))},
+		{"missing name", `{"email": "carol@example.com", "password": "pa55word1234"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPost, "/v1/users", "", tt.body)
+			if res.status != http.StatusUnprocessableEntity {
+				t.Errorf("got status %d, want %d: %s", res.status, http.StatusUnprocessableEntity, res.body)
+			}
+		})
+	}
+}
+
+func TestActivateUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	ctx := context.Background()
+
+	user := &data.User{Name: "Alice", Email: "alice@example.com"}
+	err := user.Password.Set("pa55word1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.userModel.Insert(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := app.tokenModel.New(ctx, user.ID, time.Hour, data.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := app.tokenModel.New(ctx, user.ID, -time.Hour, data.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authentication, err := app.tokenModel.New(ctx, user.ID, time.Hour, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{"expired token", expired.Plaintext, http.StatusUnprocessableEntity},
+		{"unknown token", strings.Repeat("A", 26), http.StatusUnprocessableEntity},
+		{"token of another scope", authentication.Plaintext, http.StatusUnprocessableEntity},
+		{"malformed token", "abc", http.StatusUnprocessableEntity},
+		{"valid token", token.Plaintext, http.StatusOK},
+		{"token used again", token.Plaintext, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPut, "/v1/users/activated", "", fmt.Sprintf(`{"token": %q}`, tt.token))
+			if res.status != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+			if res.status != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				User data.User `json:"user"`
+			}
+			res.decode(t, &got)
+			if got.User.ID != user.ID || !got.User.Activated {
+				t.Errorf("got user %+v, want user %d activated", got.User, user.ID)
+			}
+		})
+	}
+
+	stored, err := app.userModel.Get(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Activated {
+		t.Error("stored user is not activated")
+	}
+}
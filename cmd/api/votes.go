@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+
+	"victortillett.net/basic/internal/data"
+	"victortillett.net/basic/internal/validator"
+)
+
+// voteHandler up- or downvotes a comment as the current user, replacing any
+// earlier vote of theirs
+func (a *applicationDependencies) voteHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Value int `json:"value"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidateVote(v, incomingData.Value)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	a.setVote(w, r, incomingData.Value)
+}
+
+// withdrawVoteHandler takes back the current user's vote. Withdrawing a vote
+// that was never cast succeeds too.
+func (a *applicationDependencies) withdrawVoteHandler(w http.ResponseWriter, r *http.Request) {
+	a.setVote(w, r, 0)
+}
+
+// setVote records the current user's vote on the comment in the URL and
+// responds with it and the comment's new score
+func (a *applicationDependencies) setVote(w http.ResponseWriter, r *http.Request, value int) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	viewer, err := a.viewer(r)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+		return
+	}
+
+	comment, err := a.commentStore(r).Get(r.Context(), id, viewer)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !viewer.CanSee(comment) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// The store checks visibility again under its lock, as the comment may
+	// have been hidden since it was loaded
+	score, err := a.voteModel.Set(r.Context(), comment.ID, viewer, value)
+	if err != nil {
+		switch {
+		case err == data.ErrRecordNotFound:
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"vote": data.Vote{Value: value, Score: score}}, nil)
+	if err != nil {
+		a.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,206 @@
+// Filename: cmd/api/votes_test.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"victortillett.net/basic/internal/data"
+)
+
+type voteResponse struct {
+	Vote data.Vote `json:"vote"`
+}
+
+func votePath(commentID int64) string {
+	return fmt.Sprintf("/v1/comments/%d/vote", commentID)
+}
+
+func TestVote(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	comment := ts.createTestComment(t, alice, "vote on me")
+
+	steps := []struct {
+		name      string
+		method    string
+		token     string
+		body      string
+		wantVote  int
+		wantScore int
+	}{
+		{"upvote", http.MethodPut, alice, `{"value": 1}`, 1, 1},
+		{"repeated upvote", http.MethodPut, alice, `{"value": 1}`, 1, 1},
+		{"another user", http.MethodPut, bob, `{"value": 1}`, 1, 2},
+		{"switched to downvote", http.MethodPut, bob, `{"value": -1}`, -1, 0},
+		{"withdrawn", http.MethodDelete, alice, "", 0, -1},
+		{"withdrawn again", http.MethodDelete, alice, "", 0, -1},
+	}
+
+	for _, step := range steps {
+		res := ts.do(t, step.method, votePath(comment.ID), step.token, step.body)
+		if res.status != http.StatusOK {
+			t.Fatalf("%s: got status %d, want %d: %s", step.name, res.status, http.StatusOK, res.body)
+		}
+
+		var got voteResponse
+		res.decode(t, &got)
+		if got.Vote.Value != step.wantVote || got.Vote.Score != step.wantScore {
+			t.Errorf("%s: got vote %+v, want value %d and score %d", step.name, got.Vote, step.wantVote, step.wantScore)
+		}
+	}
+
+	res := ts.do(t, http.MethodGet, fmt.Sprintf("/v1/comments/%d", comment.ID), "", "")
+	var got commentResponse
+	res.decode(t, &got)
+	if got.Comment.Score != -1 {
+		t.Errorf("got score %d on the comment, want -1", got.Comment.Score)
+	}
+}
+
+func TestVoteErrors(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	comment := ts.createTestComment(t, alice, "vote on me")
+
+	tests := []struct {
+		name       string
+		id         int64
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"anonymous", comment.ID, "", `{"value": 1}`, http.StatusUnauthorized},
+		{"zero", comment.ID, alice, `{"value": 0}`, http.StatusUnprocessableEntity},
+		{"too large", comment.ID, alice, `{"value": 2}`, http.StatusUnprocessableEntity},
+		{"missing value", comment.ID, alice, `{}`, http.StatusUnprocessableEntity},
+		{"not a number", comment.ID, alice, `{"value": "up"}`, http.StatusBadRequest},
+		{"missing comment", 999, alice, `{"value": 1}`, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, http.MethodPut, votePath(tt.id), tt.token, tt.body)
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+}
+
+func TestVoteOnPendingComment(t *testing.T) {
+	app := newTestApplication(t)
+	app.config.moderation.premoderate = true
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	bob := createTestUser(t, app, "Bob")
+	moderator := createTestUser(t, app, "Carol", data.PermissionCommentsWrite, data.PermissionCommentsModerate)
+	pending := createTargetComment(t, ts, alice, "wait for me", "articles", "1")
+
+	// Those who cannot see the comment cannot learn it exists by voting
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		wantStatus int
+	}{
+		{"vote by another user", http.MethodPut, bob, http.StatusNotFound},
+		{"withdrawal by another user", http.MethodDelete, bob, http.StatusNotFound},
+		{"vote by the author", http.MethodPut, alice, http.StatusOK},
+		{"vote by a moderator", http.MethodPut, moderator, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := ts.do(t, tt.method, votePath(pending.ID), tt.token, `{"value": 1}`)
+			if res.status != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", res.status, tt.wantStatus, res.body)
+			}
+		})
+	}
+}
+
+func TestListCommentsByScore(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+
+	alice := createTestUser(t, app, "Alice", data.PermissionCommentsWrite)
+	voters := []string{alice, createTestUser(t, app, "Bob"), createTestUser(t, app, "Carol")}
+
+	votes := map[string]int{"comment 1": 2, "comment 2": -2, "comment 3": 3, "comment 4": 0}
+	for i := 1; i <= 4; i++ {
+		content := fmt.Sprintf("comment %d", i)
+		comment := ts.createTestComment(t, alice, content)
+		score := votes[content]
+		for _, voter := range voters {
+			switch {
+			case score > 0:
+				score--
+				ts.do(t, http.MethodPut, votePath(comment.ID), voter, `{"value": 1}`)
+			case score < 0:
+				score++
+				ts.do(t, http.MethodPut, votePath(comment.ID), voter, `{"value": -1}`)
+			}
+		}
+	}
+
+	want := "comment 3|comment 1|comment 4|comment 2"
+	for _, sort := range []string{"top", "hot"} {
+		t.Run(sort+" by page", func(t *testing.T) {
+			res := ts.do(t, http.MethodGet, "/v1/comments?sort="+sort, "", "")
+			if res.status != http.StatusOK {
+				t.Fatalf("got status %d: %s", res.status, res.body)
+			}
+
+			var got commentListResponse
+			res.decode(t, &got)
+			var content []string
+			for _, comment := range got.Comments {
+				content = append(content, comment.Content)
+			}
+			if strings.Join(content, "|") != want {
+				t.Errorf("got %q, want %q", strings.Join(content, "|"), want)
+			}
+		})
+
+		t.Run(sort+" by cursor", func(t *testing.T) {
+			var content []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > 4 {
+					t.Fatal("cursor pagination did not terminate")
+				}
+
+				query := url.Values{"sort": {sort}, "page_size": {"2"}, "cursor": {cursor}}
+				res := ts.do(t, http.MethodGet, "/v1/comments?"+query.Encode(), "", "")
+				if res.status != http.StatusOK {
+					t.Fatalf("got status %d: %s", res.status, res.body)
+				}
+
+				var got commentListResponse
+				res.decode(t, &got)
+				for _, comment := range got.Comments {
+					content = append(content, comment.Content)
+				}
+				if got.Metadata.NextCursor == "" {
+					break
+				}
+				cursor = got.Metadata.NextCursor
+			}
+
+			if strings.Join(content, "|") != want {
+				t.Errorf("got %q, want %q", strings.Join(content, "|"), want)
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ module victortillett.net/basic
 
 go 1.24.6
 
-require github.com/julienschmidt/httprouter v1.3.0
-
-require github.com/lib/pq v1.10.9 // indirect
+require (
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.45.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -4,8 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"time"
 	"fmt"
+	"log/slog"
+	"time"
 
 	"victortillett.net/basic/internal/validator"
 )
@@ -14,72 +15,108 @@ var (
 	ErrRecordNotFound = errors.New("record not found")
 	ErrEditConflict   = errors.New("edit conflict")
 )
+
 // Define a Comment struct to represent a comment in the system
 type Comment struct {
-	ID        int64     `json:"id"`
-	Content   string    `json:"content"`
-	Author    string    `json:"author"`
-	CreatedAt time.Time `json:"-"`
-	Version   int32     `json:"version"`
+	ID         int64      `json:"id"`
+	ParentID   *int64     `json:"parent_id,omitempty"`
+	UserID     int64      `json:"user_id,omitempty"`
+	Content    string     `json:"content"`
+	Author     string     `json:"author"`
+	CreatedAt  time.Time  `json:"-"`
+	UpdatedAt  time.Time  `json:"-"`
+	Version    int32      `json:"version"`
+	Score      int        `json:"score"`
+	Target     Target     `json:"target,omitzero"`
+	Status     string     `json:"status"`
+	Hidden     bool       `json:"hidden,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	ReplyCount int        `json:"reply_count"`
+	Replies    []*Comment `json:"replies,omitempty"`
+	// ModerationReason explains why a comment was rejected
+	ModerationReason string `json:"moderation_reason,omitempty"`
+	// Reactions is only loaded where the API shows them; nil means not loaded
+	Reactions []ReactionCount `json:"reactions,omitempty"`
 }
 
-// This next bit is for pagination
+// slowQueryThreshold is how long a query may run before it is logged
+const slowQueryThreshold = 500 * time.Millisecond
 
-type Metadata struct {
-	CurrentPage  int `json:"current_page"`
-	PageSize     int `json:"page_size"`
-	FirstPage    int `json:"first_page"`
-	LastPage     int `json:"last_page"`
-	TotalRecords int `json:"total_records"`
+// Define a CommentModel struct which wraps a sql.DB connection pool. Queries
+// are cancelled after QueryTimeout and Logger, when set, is warned about slow
+// ones.
+type CommentModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Logger       *slog.Logger
 }
 
-func calculateMetadata(totalRecords, page, pageSize int) Metadata {
-	if totalRecords == 0 {
-		return Metadata{}
-	}
-	return Metadata{
-		CurrentPage:  page,
-		PageSize:     pageSize,
-		FirstPage:    1,
-		LastPage:    (totalRecords + pageSize - 1) / pageSize,
-		TotalRecords: totalRecords,
-	}
+// WithLogger returns a copy of the model that logs through logger
+func (c CommentModel) WithLogger(logger *slog.Logger) CommentStore {
+	c.Logger = logger
+	return c
 }
-// Define a CommentModel struct which wraps a sql.DB connection pool
-type CommentModel struct {
-	DB *sql.DB
+
+// logSlow warns about a query begun at start that ran for too long
+func (c CommentModel) logSlow(method string, start time.Time) {
+	if elapsed := time.Since(start); c.Logger != nil && elapsed > slowQueryThreshold {
+		c.Logger.Warn("slow query", "method", "CommentModel."+method, "duration", elapsed)
+	}
 }
 
-// Create a new comment
-func (c CommentModel) Insert(comment *Comment) error {
+// Create a new comment. It is approved unless it has another status.
+func (c CommentModel) Insert(ctx context.Context, comment *Comment) error {
+	defer c.logSlow("Insert", time.Now())
+
+	if comment.Status == "" {
+		comment.Status = CommentApproved
+	}
+
 	query := `
-		INSERT INTO comments (content, author)
-		VALUES ($1, $2)
-		RETURNING id, created_at, version`
-	args := []any{comment.Content, comment.Author}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		INSERT INTO comments (parent_id, user_id, content, author, target_namespace, target_id, status)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7)
+		RETURNING id, created_at, updated_at, version`
+	args := []any{comment.ParentID, comment.UserID, comment.Content, comment.Author, comment.Target.Namespace, comment.Target.ID, comment.Status}
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
 	defer cancel()
 	return c.DB.QueryRowContext(ctx, query, args...).Scan(
 		&comment.ID,
 		&comment.CreatedAt,
+		&comment.UpdatedAt,
 		&comment.Version,
 	)
 }
-// Get a specific comment by ID
-func (c CommentModel) Get(id int64) (*Comment, error) {
+
+// Get a specific comment by ID. Its reply count only includes the replies
+// viewer can see.
+func (c CommentModel) Get(ctx context.Context, id int64, viewer Viewer) (*Comment, error) {
+	defer c.logSlow("Get", time.Now())
+
 	query := `
-		SELECT id, created_at, content, author, version
+		SELECT id, parent_id, COALESCE(user_id, 0), created_at, updated_at, content, author, version, score, COALESCE(target_namespace, ''), COALESCE(target_id, ''), status, moderation_reason, hidden_at IS NOT NULL, deleted_at,
+		       (SELECT count(*) FROM comments r WHERE r.parent_id = comments.id AND ((r.status = 'approved' AND r.hidden_at IS NULL) OR $2 OR r.user_id = $3))
 		FROM comments
-		WHERE id = $1`
+		WHERE id = $1 AND deleted_at IS NULL`
 	var comment Comment
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
 	defer cancel()
-	err := c.DB.QueryRowContext(ctx, query, id).Scan(
+	err := c.DB.QueryRowContext(ctx, query, id, viewer.Moderator, viewer.UserID).Scan(
 		&comment.ID,
+		&comment.ParentID,
+		&comment.UserID,
 		&comment.CreatedAt,
+		&comment.UpdatedAt,
 		&comment.Content,
 		&comment.Author,
 		&comment.Version,
+		&comment.Score,
+		&comment.Target.Namespace,
+		&comment.Target.ID,
+		&comment.Status,
+		&comment.ModerationReason,
+		&comment.Hidden,
+		&comment.DeletedAt,
+		&comment.ReplyCount,
 	)
 	if err != nil {
 		switch {
@@ -91,36 +128,90 @@ func (c CommentModel) Get(id int64) (*Comment, error) {
 	}
 	return &comment, nil
 }
-// Update an existing comment
-func (c CommentModel) Update(comment *Comment) error {
+
+// Update an existing comment. The content being replaced is written to
+// comment_revisions in the same transaction.
+func (c CommentModel) Update(ctx context.Context, comment *Comment) error {
+	defer c.logSlow("Update", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the row makes a concurrent update with the same version wait
+	// and then find that the version has moved on
+	query := `
+		INSERT INTO comment_revisions (comment_id, version, content)
+		SELECT id, version, content
+		FROM comments
+		WHERE id = $1 AND version = $2 AND deleted_at IS NULL
+		FOR UPDATE`
+	result, err := tx.ExecContext(ctx, query, comment.ID, comment.Version)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	query = `
+		UPDATE comments
+		SET content = $1, updated_at = now(), version = version + 1
+		WHERE id = $2
+		RETURNING updated_at, version`
+	err = tx.QueryRowContext(ctx, query, comment.Content, comment.ID).Scan(&comment.UpdatedAt, &comment.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete a comment by ID. The row is only tombstoned: it disappears from
+// every listing but keeps its content so a moderator can restore it, and its
+// replies stay reachable through the thread.
+func (c CommentModel) Delete(ctx context.Context, id int64) error {
+	defer c.logSlow("Delete", time.Now())
+
 	query := `
 		UPDATE comments
-		SET content = $1, author = $2, version = version + 1
-		WHERE id = $3 AND version = $4
-		RETURNING version`
-	args := []any{
-		comment.Content,
-		comment.Author,
-		comment.ID,
-		comment.Version,
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		SET deleted_at = now(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL`
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
 	defer cancel()
-	err := c.DB.QueryRowContext(ctx, query, args...).Scan(&comment.Version)
+	result, err := c.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
-		default:
-			return err
-		}
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
 	}
 	return nil
 }
-// Delete a comment by ID
-func (c CommentModel) Delete(id int64) error {
-	query := `DELETE FROM comments WHERE id = $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+
+// Restore a deleted comment by ID. Like deleting, it bumps the version so
+// that copies fetched before either change no longer match.
+func (c CommentModel) Restore(ctx context.Context, id int64) error {
+	defer c.logSlow("Restore", time.Now())
+
+	query := `
+		UPDATE comments
+		SET deleted_at = NULL, version = version + 1
+		WHERE id = $1 AND deleted_at IS NOT NULL`
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
 	defer cancel()
 	result, err := c.DB.ExecContext(ctx, query, id)
 	if err != nil {
@@ -135,61 +226,339 @@ func (c CommentModel) Delete(id int64) error {
 	}
 	return nil
 }
+
+// Permanently remove comments deleted more than olderThan ago. Tombstones
+// that still have replies are kept so the thread below them survives; they
+// become eligible once their replies are gone.
+func (c CommentModel) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int64, error) {
+	defer c.logSlow("PurgeDeleted", time.Now())
+
+	query := `
+		DELETE FROM comments
+		WHERE deleted_at < $1
+		AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = comments.id)`
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+	result, err := c.DB.ExecContext(ctx, query, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Get all deleted comments, most recently deleted first by default
+func (c CommentModel) GetDeleted(ctx context.Context, filters Filters) ([]*Comment, Metadata, error) {
+	defer c.logSlow("GetDeleted", time.Now())
+
+	validSortFields := map[string]string{
+		"id":      "id",
+		"deleted": "deleted_at",
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, parent_id, COALESCE(user_id, 0), created_at, updated_at, content, author, version, score, COALESCE(target_namespace, ''), COALESCE(target_id, ''), status, moderation_reason, hidden_at IS NOT NULL, deleted_at,
+		       (SELECT count(*) FROM comments r WHERE r.parent_id = comments.id)
+		FROM comments
+		WHERE deleted_at IS NOT NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $1 OFFSET $2`, validSortFields[filters.sortKey()], filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var cm Comment
+		err := rows.Scan(&totalRecords, &cm.ID, &cm.ParentID, &cm.UserID, &cm.CreatedAt, &cm.UpdatedAt, &cm.Content, &cm.Author, &cm.Version, &cm.Score, &cm.Target.Namespace, &cm.Target.ID, &cm.Status, &cm.ModerationReason, &cm.Hidden, &cm.DeletedAt, &cm.ReplyCount)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, &cm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return comments, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
 // Validate the comment fields
 func ValidateComment(v *validator.Validator, comment *Comment) {
 	v.Check(comment.Content != "", "content", "must be provided")
 	v.Check(len(comment.Content) <= 100, "content", "must not be more than 100 bytes long")
-	v.Check(comment.Author != "", "author", "must be provided")
-	v.Check(len(comment.Author) <= 25, "author", "must not be more than 25 bytes long")
-}
-
-// Get all comments with pagination and sorting
-func (c CommentModel) GetAll(page, pageSize int, sort string) ([]*Comment, Metadata, error) {
-    validSortFields := map[string]string{
-        "id":      "id",
-        "author":  "author",
-        "created": "created_at",
-    }
-
-    sortColumn, ok := validSortFields[sort]
-    if !ok {
-        sortColumn = "id"
-    }
-
-    query := fmt.Sprintf(`
-        SELECT count(*) OVER(), id, created_at, content, author, version
-        FROM comments
-        ORDER BY %s
-        LIMIT $1 OFFSET $2`, sortColumn)
-
-    args := []any{pageSize, (page - 1) * pageSize}
-
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-    defer cancel()
-
-    rows, err := c.DB.QueryContext(ctx, query, args...)
-    if err != nil {
-        return nil, Metadata{}, err
-    }
-    defer rows.Close()
-
-    totalRecords := 0
-    comments := []*Comment{}
-
-    for rows.Next() {
-        var cm Comment
-        err := rows.Scan(&totalRecords, &cm.ID, &cm.CreatedAt, &cm.Content, &cm.Author, &cm.Version)
-        if err != nil {
-            return nil, Metadata{}, err
-        }
-        comments = append(comments, &cm)
-    }
-
-    if err = rows.Err(); err != nil {
-        return nil, Metadata{}, err
-    }
-
-    metadata := calculateMetadata(totalRecords, page, pageSize)
-
-    return comments, metadata, nil
+}
+
+// CommentSearch holds the optional search and filter parameters for GetAll.
+// Zero values mean the filter is not applied.
+type CommentSearch struct {
+	Query         string     `json:"q,omitempty"`
+	Author        string     `json:"author,omitempty"`
+	AuthorPrefix  string     `json:"author_prefix,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	Target        Target     `json:"target,omitzero"`
+	Viewer        Viewer     `json:"-"`
+}
+
+// Viewer is who a listing is for. Everyone sees approved comments that were
+// not hidden by reports, authors also see their own and moderators see them
+// all.
+type Viewer struct {
+	UserID    int64
+	Moderator bool
+}
+
+// CanSee reports whether the viewer may see comment
+func (v Viewer) CanSee(comment *Comment) bool {
+	return (comment.Status == CommentApproved && !comment.Hidden) || v.Moderator || (comment.UserID != 0 && comment.UserID == v.UserID)
+}
+
+// CanModify reports whether the viewer may edit or delete comment: only its
+// author or a moderator may
+func (v Viewer) CanModify(comment *Comment) bool {
+	return v.Moderator || (comment.UserID != 0 && comment.UserID == v.UserID)
+}
+
+// Check whether any filter is set
+func (s CommentSearch) IsEmpty() bool {
+	s.Viewer = Viewer{}
+	return s == CommentSearch{}
+}
+
+// Validate the search and filter parameters
+func ValidateCommentSearch(v *validator.Validator, search CommentSearch) {
+	v.Check(len(search.Query) <= 200, "q", "must not be more than 200 bytes long")
+	v.Check(len(search.Author) <= 25, "author", "must not be more than 25 bytes long")
+	v.Check(len(search.AuthorPrefix) <= 25, "author_prefix", "must not be more than 25 bytes long")
+	if search.CreatedAfter != nil && search.CreatedBefore != nil {
+		v.Check(search.CreatedAfter.Before(*search.CreatedBefore), "created_before", "must be later than created_after")
+	}
+}
+
+// commentSortColumns maps the public sort keys to the SQL expression they
+// order by and the type their keyset values are cast back to
+var commentSortColumns = map[string]struct{ expr, cast string }{
+	"id":      {"id", "bigint"},
+	"author":  {"author", "text"},
+	"created": {"created_at", "timestamptz"},
+	// Negated so that the default ascending order puts the best match first
+	"relevance": {"-ts_rank(search_vector, websearch_to_tsquery('english', $1))", "real"},
+	"top":       {"-score", "integer"},
+	"hot":       {"-" + hotRank, "double precision"},
+}
+
+// hotRank balances score against age: every tenfold increase in score is
+// worth as much as being 12.5 hours newer. It depends only on the score and
+// creation time, never on the current time, so keyset cursors stay valid.
+const hotRank = `(sign(score::float8) * log(greatest(abs(score), 1)::float8) + extract(epoch FROM created_at)::float8 / 45000)`
+
+// commentSearchConditions applies a CommentSearch passed as $1 to $9
+const commentSearchConditions = `
+		($1 = '' OR search_vector @@ websearch_to_tsquery('english', $1))
+		AND ($2 = '' OR author = $2)
+		AND ($3 = '' OR starts_with(author, $3))
+		AND ($4::timestamptz IS NULL OR created_at > $4)
+		AND ($5::timestamptz IS NULL OR created_at < $5)
+		AND ($6 = '' OR (target_namespace = $6 AND target_id = $7))
+		AND ((status = 'approved' AND hidden_at IS NULL) OR $8 OR user_id = $9)`
+
+func commentSearchArgs(search CommentSearch) []any {
+	return []any{
+		search.Query,
+		search.Author,
+		search.AuthorPrefix,
+		search.CreatedAfter,
+		search.CreatedBefore,
+		search.Target.Namespace,
+		search.Target.ID,
+		search.Viewer.Moderator,
+		search.Viewer.UserID,
+	}
+}
+
+// Get all comments matching search, with pagination and sorting
+func (c CommentModel) GetAll(ctx context.Context, search CommentSearch, filters Filters) ([]*Comment, Metadata, error) {
+	defer c.logSlow("GetAll", time.Now())
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, parent_id, COALESCE(user_id, 0), created_at, updated_at, content, author, version, score, COALESCE(target_namespace, ''), COALESCE(target_id, ''), status, moderation_reason, hidden_at IS NOT NULL, deleted_at,
+		       (SELECT count(*) FROM comments r WHERE r.parent_id = comments.id AND ((r.status = 'approved' AND r.hidden_at IS NULL) OR $8 OR r.user_id = $9))
+		FROM comments
+		WHERE deleted_at IS NULL AND %s
+		ORDER BY %s %s, id ASC
+		LIMIT $10 OFFSET $11`, commentSearchConditions, commentSortColumns[filters.sortKey()].expr, filters.sortDirection())
+
+	args := append(commentSearchArgs(search), filters.limit(), filters.offset())
+
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var cm Comment
+		err := rows.Scan(&totalRecords, &cm.ID, &cm.ParentID, &cm.UserID, &cm.CreatedAt, &cm.UpdatedAt, &cm.Content, &cm.Author, &cm.Version, &cm.Score, &cm.Target.Namespace, &cm.Target.ID, &cm.Status, &cm.ModerationReason, &cm.Hidden, &cm.DeletedAt, &cm.ReplyCount)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, &cm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	if !search.IsEmpty() {
+		metadata.Search = &search
+	}
+
+	return comments, metadata, nil
+}
+
+// Get the page of comments matching search that follows after, using keyset
+// pagination on the sort column and id. A nil after starts from the
+// beginning. The returned cursor is nil on the last page.
+func (c CommentModel) GetAllAfter(ctx context.Context, search CommentSearch, filters Filters, after *Cursor) ([]*Comment, *Cursor, error) {
+	defer c.logSlow("GetAllAfter", time.Now())
+
+	sortKey := filters.sortKey()
+	column := commentSortColumns[sortKey]
+
+	comparison := ">"
+	if filters.sortDirection() == "DESC" {
+		comparison = "<"
+	}
+
+	var afterValue *string
+	var afterID int64
+	if after != nil {
+		afterValue = &after.Value
+		afterID = after.ID
+	}
+
+	// One extra row tells us whether there is another page
+	query := fmt.Sprintf(`
+		SELECT (%[1]s)::text, id, parent_id, COALESCE(user_id, 0), created_at, updated_at, content, author, version, score, COALESCE(target_namespace, ''), COALESCE(target_id, ''), status, moderation_reason, hidden_at IS NOT NULL, deleted_at,
+		       (SELECT count(*) FROM comments r WHERE r.parent_id = comments.id AND ((r.status = 'approved' AND r.hidden_at IS NULL) OR $8 OR r.user_id = $9))
+		FROM comments
+		WHERE deleted_at IS NULL AND %[2]s
+		AND ($10::text IS NULL OR %[1]s %[3]s $10::%[4]s OR (%[1]s = $10::%[4]s AND id > $11))
+		ORDER BY %[1]s %[5]s, id ASC
+		LIMIT $12`, column.expr, commentSearchConditions, comparison, column.cast, filters.sortDirection())
+
+	args := append(commentSearchArgs(search), afterValue, afterID, filters.limit()+1)
+
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	sortValues := []string{}
+
+	for rows.Next() {
+		var cm Comment
+		var sortValue string
+		err := rows.Scan(&sortValue, &cm.ID, &cm.ParentID, &cm.UserID, &cm.CreatedAt, &cm.UpdatedAt, &cm.Content, &cm.Author, &cm.Version, &cm.Score, &cm.Target.Namespace, &cm.Target.ID, &cm.Status, &cm.ModerationReason, &cm.Hidden, &cm.DeletedAt, &cm.ReplyCount)
+		if err != nil {
+			return nil, nil, err
+		}
+		comments = append(comments, &cm)
+		sortValues = append(sortValues, sortValue)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(comments) <= filters.limit() {
+		return comments, nil, nil
+	}
+
+	comments = comments[:filters.limit()]
+	last := len(comments) - 1
+	next := &Cursor{Sort: filters.Sort, Search: search.Digest(), Value: sortValues[last], ID: comments[last].ID}
+	return comments, next, nil
+}
+
+// Get a comment and its replies, nested down to maxDepth levels below it.
+// Deleted replies stay in the tree as placeholders with their text removed.
+// The tree holds every reply, but reply counts only include those viewer
+// can see.
+func (c CommentModel) GetThread(ctx context.Context, id int64, maxDepth int, viewer Viewer) (*Comment, error) {
+	defer c.logSlow("GetThread", time.Now())
+
+	query := `
+		WITH RECURSIVE thread AS (
+			SELECT id, parent_id, user_id, created_at, updated_at, content, author, version, score, target_namespace, target_id, status, moderation_reason, hidden_at, deleted_at, 0 AS depth
+			FROM comments
+			WHERE id = $1 AND deleted_at IS NULL
+			UNION ALL
+			SELECT c.id, c.parent_id, c.user_id, c.created_at, c.updated_at, c.content, c.author, c.version, c.score, c.target_namespace, c.target_id, c.status, c.moderation_reason, c.hidden_at, c.deleted_at, t.depth + 1
+			FROM comments c
+			INNER JOIN thread t ON c.parent_id = t.id
+			WHERE t.depth < $2
+		)
+		SELECT id, parent_id, COALESCE(user_id, 0), created_at, updated_at,
+		       CASE WHEN deleted_at IS NULL THEN content ELSE '' END,
+		       CASE WHEN deleted_at IS NULL THEN author ELSE '' END,
+		       version, score, COALESCE(target_namespace, ''), COALESCE(target_id, ''), status, moderation_reason, hidden_at IS NOT NULL, deleted_at,
+		       (SELECT count(*) FROM comments r WHERE r.parent_id = thread.id AND ((r.status = 'approved' AND r.hidden_at IS NULL) OR $3 OR r.user_id = $4))
+		FROM thread
+		ORDER BY depth, created_at, id`
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, id, maxDepth, viewer.Moderator, viewer.UserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Rows arrive level by level, so a parent is always seen before its replies
+	var root *Comment
+	nodes := make(map[int64]*Comment)
+	for rows.Next() {
+		var cm Comment
+		err := rows.Scan(&cm.ID, &cm.ParentID, &cm.UserID, &cm.CreatedAt, &cm.UpdatedAt, &cm.Content, &cm.Author, &cm.Version, &cm.Score, &cm.Target.Namespace, &cm.Target.ID, &cm.Status, &cm.ModerationReason, &cm.Hidden, &cm.DeletedAt, &cm.ReplyCount)
+		if err != nil {
+			return nil, err
+		}
+		nodes[cm.ID] = &cm
+		if root == nil {
+			root = &cm
+			continue
+		}
+		parent := nodes[*cm.ParentID]
+		parent.Replies = append(parent.Replies, &cm)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if root == nil {
+		return nil, ErrRecordNotFound
+	}
+	return root, nil
 }
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks the last row of a page in keyset pagination: the value of the
+// sort column for that row and its ID, which breaks ties. Search is the
+// digest of the search the cursor was issued for, so that it cannot be
+// replayed against different results.
+type Cursor struct {
+	Sort   string `json:"s"`
+	Search string `json:"q,omitempty"`
+	Value  string `json:"v"`
+	ID     int64  `json:"id"`
+}
+
+// Digest identifies the search and filter parameters, leaving out the viewer
+func (s CommentSearch) Digest() string {
+	payload, _ := json.Marshal(s)
+	sum := sha256.Sum256(payload)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// Encode serializes the cursor and signs it with secret so that clients
+// cannot forge or tamper with it
+func (c Cursor) Encode(secret []byte) string {
+	payload, _ := json.Marshal(c)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor verifies and parses a cursor produced by Encode
+func DecodeCursor(token string, secret []byte) (*Cursor, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(payload, &cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// CursorMetadata describes a page of results returned by a keyset query
+type CursorMetadata struct {
+	PageSize   int            `json:"page_size"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	Search     *CommentSearch `json:"search,omitempty"`
+}
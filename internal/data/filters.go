@@ -0,0 +1,74 @@
+package data
+
+import (
+	"strings"
+
+	"victortillett.net/basic/internal/validator"
+)
+
+// Filters holds the pagination and sorting parameters for list queries
+type Filters struct {
+	Page         int
+	PageSize     int
+	Sort         string
+	SortSafelist []string
+}
+
+// Validate the pagination and sorting parameters
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
+	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+}
+
+// sortKey returns the sort field without its direction prefix. The value has
+// already been checked against the safelist, so anything else is a bug.
+func (f Filters) sortKey() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+// sortDirection returns DESC for a "-field" sort and ASC otherwise
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+func (f Filters) limit() int {
+	return f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// Metadata describes the page of results returned by a list query
+type Metadata struct {
+	CurrentPage  int            `json:"current_page"`
+	PageSize     int            `json:"page_size"`
+	FirstPage    int            `json:"first_page"`
+	LastPage     int            `json:"last_page"`
+	TotalRecords int            `json:"total_records"`
+	Search       *CommentSearch `json:"search,omitempty"`
+}
+
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     (totalRecords + pageSize - 1) / pageSize,
+		TotalRecords: totalRecords,
+	}
+}
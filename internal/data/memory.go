@@ -0,0 +1,226 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore holds every record of the in-memory backend behind a single
+// lock. It is meant for tests and local demos; nothing survives a restart.
+type MemoryStore struct {
+	mu            sync.RWMutex
+	comments      map[int64]*Comment
+	revisions     map[int64][]*Revision
+	lastCommentID int64
+	users         map[int64]*User
+	lastUserID    int64
+	tokens        map[string]*Token
+	permissions   map[int64]Permissions
+	reactions     map[memoryReaction]bool
+	votes         map[memoryVote]int
+	namespaces    map[string]*Namespace
+	reports       map[memoryReportKey]*memoryReport
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		comments:    make(map[int64]*Comment),
+		revisions:   make(map[int64][]*Revision),
+		users:       make(map[int64]*User),
+		tokens:      make(map[string]*Token),
+		permissions: make(map[int64]Permissions),
+		reactions:   make(map[memoryReaction]bool),
+		votes:       make(map[memoryVote]int),
+		namespaces:  make(map[string]*Namespace),
+		reports:     make(map[memoryReportKey]*memoryReport),
+	}
+}
+
+// memoryNow returns the current time at the whole-second precision of the
+// timestamp(0) columns
+func memoryNow() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+// Define a MemoryUserModel struct which keeps users in a MemoryStore
+type MemoryUserModel struct {
+	store *MemoryStore
+}
+
+// emailTaken reports whether another user already has email. Emails are
+// compared case-insensitively like the citext column.
+func (s *MemoryStore) emailTaken(email string, exceptID int64) bool {
+	for _, user := range s.users {
+		if user.ID != exceptID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// storedUser returns the copy of user that the store keeps. The plaintext
+// password is dropped just as it never reaches the database.
+func storedUser(user *User) *User {
+	stored := *user
+	stored.Password.plaintext = nil
+	return &stored
+}
+
+// Create a new user and grant them the permission codes
+func (u MemoryUserModel) Insert(ctx context.Context, user *User, codes ...string) error {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+
+	if u.store.emailTaken(user.Email, 0) {
+		return ErrDuplicateEmail
+	}
+
+	u.store.lastUserID++
+	user.ID = u.store.lastUserID
+	user.CreatedAt = memoryNow()
+	user.Version = 1
+	u.store.users[user.ID] = storedUser(user)
+	u.store.grant(user.ID, codes)
+	return nil
+}
+
+// Get a specific user by ID
+func (u MemoryUserModel) Get(ctx context.Context, id int64) (*User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+
+	user, found := u.store.users[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return storedUser(user), nil
+}
+
+// Get a specific user by email address
+func (u MemoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+
+	for _, user := range u.store.users {
+		if strings.EqualFold(user.Email, email) {
+			return storedUser(user), nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// Update an existing user
+func (u MemoryUserModel) Update(ctx context.Context, user *User) error {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+
+	current, found := u.store.users[user.ID]
+	if !found || current.Version != user.Version {
+		return ErrEditConflict
+	}
+	if u.store.emailTaken(user.Email, user.ID) {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+	updated := storedUser(user)
+	updated.CreatedAt = current.CreatedAt
+	u.store.users[user.ID] = updated
+	return nil
+}
+
+// Get the user that owns a valid (unexpired) token of the given scope
+func (u MemoryUserModel) GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	u.store.mu.RLock()
+	defer u.store.mu.RUnlock()
+
+	token, found := u.store.tokens[string(tokenHash[:])]
+	if !found || token.Scope != tokenScope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+	user, found := u.store.users[token.UserID]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+	return storedUser(user), nil
+}
+
+// Define a MemoryTokenModel struct which keeps tokens in a MemoryStore
+type MemoryTokenModel struct {
+	store *MemoryStore
+}
+
+// Generate a new token for a user and store it
+func (t MemoryTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+	err = t.Insert(ctx, token)
+	return token, err
+}
+
+// Store a token. Only the hash is kept, as in the tokens table.
+func (t MemoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	stored := *token
+	stored.Plaintext = ""
+	t.store.tokens[string(token.Hash)] = &stored
+	return nil
+}
+
+// Delete all of a user's tokens for the given scope
+func (t MemoryTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	for hash, token := range t.store.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(t.store.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// memoryPermissionCodes are the codes seeded into the permissions table
+var memoryPermissionCodes = []string{PermissionCommentsWrite, PermissionCommentsModerate}
+
+// Define a MemoryPermissionModel struct which keeps grants in a MemoryStore
+type MemoryPermissionModel struct {
+	store *MemoryStore
+}
+
+// Get all permission codes granted to a user
+func (p MemoryPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	p.store.mu.RLock()
+	defer p.store.mu.RUnlock()
+
+	return slices.Clone(p.store.permissions[userID]), nil
+}
+
+// Grant one or more permission codes to a user. Unknown codes are ignored.
+func (p MemoryPermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	p.store.grant(userID, codes)
+	return nil
+}
+
+// grant adds the known codes among codes to a user's permissions. The caller
+// must hold the lock.
+func (s *MemoryStore) grant(userID int64, codes []string) {
+	for _, code := range codes {
+		if slices.Contains(memoryPermissionCodes, code) && !s.permissions[userID].Include(code) {
+			s.permissions[userID] = append(s.permissions[userID], code)
+		}
+	}
+}
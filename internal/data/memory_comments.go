@@ -0,0 +1,588 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Define a MemoryCommentModel struct which keeps comments in a MemoryStore.
+// It mirrors CommentModel, including version checks, soft deletes and
+// ordering, so handlers cannot tell the two apart.
+type MemoryCommentModel struct {
+	store *MemoryStore
+}
+
+// WithLogger returns the model unchanged; there are no queries to log
+func (c MemoryCommentModel) WithLogger(logger *slog.Logger) CommentStore {
+	return c
+}
+
+// copyComment returns a detached copy of a stored comment with its reply count
+func copyComment(stored *Comment, replyCount int) *Comment {
+	comment := *stored
+	if stored.ParentID != nil {
+		parentID := *stored.ParentID
+		comment.ParentID = &parentID
+	}
+	if stored.DeletedAt != nil {
+		deletedAt := *stored.DeletedAt
+		comment.DeletedAt = &deletedAt
+	}
+	comment.ReplyCount = replyCount
+	comment.Replies = nil
+	return &comment
+}
+
+// replyCounts returns the number of direct replies, deleted or not, that
+// viewer can see of every comment that has any
+func (s *MemoryStore) replyCounts(viewer Viewer) map[int64]int {
+	counts := make(map[int64]int)
+	for _, comment := range s.comments {
+		if comment.ParentID != nil && viewer.CanSee(comment) {
+			counts[*comment.ParentID]++
+		}
+	}
+	return counts
+}
+
+// Create a new comment. It is approved unless it has another status.
+func (c MemoryCommentModel) Insert(ctx context.Context, comment *Comment) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	if comment.Status == "" {
+		comment.Status = CommentApproved
+	}
+
+	if comment.ParentID != nil {
+		if _, found := c.store.comments[*comment.ParentID]; !found {
+			return fmt.Errorf("parent comment %d does not exist", *comment.ParentID)
+		}
+	}
+
+	c.store.lastCommentID++
+	comment.ID = c.store.lastCommentID
+	comment.CreatedAt = memoryNow()
+	comment.UpdatedAt = comment.CreatedAt
+	comment.Version = 1
+	comment.DeletedAt = nil
+	c.store.comments[comment.ID] = copyComment(comment, 0)
+	return nil
+}
+
+// Get a specific comment by ID. Its reply count only includes the replies
+// viewer can see.
+func (c MemoryCommentModel) Get(ctx context.Context, id int64, viewer Viewer) (*Comment, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	stored, found := c.store.comments[id]
+	if !found || stored.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+	return copyComment(stored, c.store.replyCounts(viewer)[id]), nil
+}
+
+// Update an existing comment, keeping the content being replaced as a revision
+func (c MemoryCommentModel) Update(ctx context.Context, comment *Comment) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	stored, found := c.store.comments[comment.ID]
+	if !found || stored.DeletedAt != nil || stored.Version != comment.Version {
+		return ErrEditConflict
+	}
+
+	now := memoryNow()
+	c.store.revisions[stored.ID] = append(c.store.revisions[stored.ID], &Revision{
+		CommentID:  stored.ID,
+		Version:    stored.Version,
+		Content:    stored.Content,
+		ReplacedAt: &now,
+	})
+
+	stored.Content = comment.Content
+	stored.UpdatedAt = now
+	stored.Version++
+
+	comment.UpdatedAt = stored.UpdatedAt
+	comment.Version = stored.Version
+	return nil
+}
+
+// Delete a comment by ID. As with CommentModel the comment is only tombstoned.
+func (c MemoryCommentModel) Delete(ctx context.Context, id int64) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	stored, found := c.store.comments[id]
+	if !found || stored.DeletedAt != nil {
+		return ErrRecordNotFound
+	}
+	now := memoryNow()
+	stored.DeletedAt = &now
+	stored.Version++
+	return nil
+}
+
+// Restore a deleted comment by ID
+func (c MemoryCommentModel) Restore(ctx context.Context, id int64) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	stored, found := c.store.comments[id]
+	if !found || stored.DeletedAt == nil {
+		return ErrRecordNotFound
+	}
+	stored.DeletedAt = nil
+	stored.Version++
+	return nil
+}
+
+// Permanently remove comments deleted more than olderThan ago that have no
+// replies. Replies are counted before anything is removed, so a tombstone
+// whose last reply goes in this run waits for the next one, as in SQL.
+func (c MemoryCommentModel) PurgeDeleted(ctx context.Context, olderThan time.Duration) (int64, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	cutoff := time.Now().Add(-olderThan)
+	counts := c.store.replyCounts(Viewer{Moderator: true})
+
+	var purged int64
+	for id, stored := range c.store.comments {
+		if stored.DeletedAt != nil && stored.DeletedAt.Before(cutoff) && counts[id] == 0 {
+			delete(c.store.comments, id)
+			delete(c.store.revisions, id)
+			c.store.deleteReactions(id)
+			c.store.deleteVotes(id)
+			c.store.deleteReports(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// memoryRow is a comment together with the computed values it is sorted by
+type memoryRow struct {
+	comment *Comment
+	rank    float32 // negated, like the relevance sort expression
+	hot     float64 // negated, like the hot sort expression
+}
+
+// compareRows orders two rows by one sort key, ascending
+func compareRows(a, b memoryRow, key string) int {
+	switch key {
+	case "author":
+		return strings.Compare(a.comment.Author, b.comment.Author)
+	case "created":
+		return a.comment.CreatedAt.Compare(b.comment.CreatedAt)
+	case "deleted":
+		return a.comment.DeletedAt.Compare(*b.comment.DeletedAt)
+	case "relevance":
+		return cmp.Compare(a.rank, b.rank)
+	case "top":
+		return cmp.Compare(b.comment.Score, a.comment.Score)
+	case "hot":
+		return cmp.Compare(a.hot, b.hot)
+	default:
+		return cmp.Compare(a.comment.ID, b.comment.ID)
+	}
+}
+
+// hotRankOf computes the hotRank expression for comment
+func hotRankOf(comment *Comment) float64 {
+	score := float64(comment.Score)
+	sign := float64(cmp.Compare(score, 0))
+	return sign*math.Log10(max(math.Abs(score), 1)) + float64(comment.CreatedAt.Unix())/45000
+}
+
+// rowOrder returns the order the SQL queries use for filters: by the sort
+// key in the requested direction, then by id ascending
+func rowOrder(filters Filters) func(a, b memoryRow) int {
+	key := filters.sortKey()
+	descending := filters.sortDirection() == "DESC"
+	return func(a, b memoryRow) int {
+		result := compareRows(a, b, key)
+		if descending {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+		return cmp.Compare(a.comment.ID, b.comment.ID)
+	}
+}
+
+func sortRows(rows []memoryRow, filters Filters) {
+	slices.SortFunc(rows, rowOrder(filters))
+}
+
+// pageRows returns the rows on the requested page and the metadata for it.
+// Like count(*) OVER(), the total is only known when the page has rows.
+func pageRows(rows []memoryRow, filters Filters) ([]*Comment, Metadata) {
+	comments := []*Comment{}
+	if filters.offset() >= len(rows) {
+		return comments, calculateMetadata(0, filters.Page, filters.PageSize)
+	}
+	end := min(filters.offset()+filters.limit(), len(rows))
+	for _, row := range rows[filters.offset():end] {
+		comments = append(comments, row.comment)
+	}
+	return comments, calculateMetadata(len(rows), filters.Page, filters.PageSize)
+}
+
+// Get all deleted comments, most recently deleted first by default
+func (c MemoryCommentModel) GetDeleted(ctx context.Context, filters Filters) ([]*Comment, Metadata, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	counts := c.store.replyCounts(Viewer{Moderator: true})
+	rows := []memoryRow{}
+	for id, stored := range c.store.comments {
+		if stored.DeletedAt != nil {
+			rows = append(rows, memoryRow{comment: copyComment(stored, counts[id])})
+		}
+	}
+	sortRows(rows, filters)
+
+	comments, metadata := pageRows(rows, filters)
+	return comments, metadata, nil
+}
+
+// searchRows returns the live comments matching search with their relevance
+func (s *MemoryStore) searchRows(search CommentSearch) []memoryRow {
+	query := parseTextQuery(search.Query)
+	counts := s.replyCounts(search.Viewer)
+
+	rows := []memoryRow{}
+	for id, stored := range s.comments {
+		if stored.DeletedAt != nil {
+			continue
+		}
+		if search.Author != "" && stored.Author != search.Author {
+			continue
+		}
+		if search.AuthorPrefix != "" && !strings.HasPrefix(stored.Author, search.AuthorPrefix) {
+			continue
+		}
+		if search.CreatedAfter != nil && !stored.CreatedAt.After(*search.CreatedAfter) {
+			continue
+		}
+		if search.CreatedBefore != nil && !stored.CreatedAt.Before(*search.CreatedBefore) {
+			continue
+		}
+		if !search.Target.IsZero() && stored.Target != search.Target {
+			continue
+		}
+		if !search.Viewer.CanSee(stored) {
+			continue
+		}
+
+		words := textWords(stored.Content)
+		if search.Query != "" && !query.matches(words) {
+			continue
+		}
+		rows = append(rows, memoryRow{
+			comment: copyComment(stored, counts[id]),
+			rank:    -query.rank(words),
+			hot:     -hotRankOf(stored),
+		})
+	}
+	return rows
+}
+
+// Get all comments matching search, with pagination and sorting
+func (c MemoryCommentModel) GetAll(ctx context.Context, search CommentSearch, filters Filters) ([]*Comment, Metadata, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	rows := c.store.searchRows(search)
+	sortRows(rows, filters)
+
+	comments, metadata := pageRows(rows, filters)
+	if !search.IsEmpty() {
+		metadata.Search = &search
+	}
+	return comments, metadata, nil
+}
+
+// sortValue formats the value of key for row as stored in a cursor
+func sortValue(row memoryRow, key string) string {
+	switch key {
+	case "author":
+		return row.comment.Author
+	case "created":
+		return row.comment.CreatedAt.Format(time.RFC3339)
+	case "relevance":
+		return strconv.FormatFloat(float64(row.rank), 'g', -1, 32)
+	case "top":
+		return strconv.Itoa(-row.comment.Score)
+	case "hot":
+		return strconv.FormatFloat(row.hot, 'g', -1, 64)
+	default:
+		return strconv.FormatInt(row.comment.ID, 10)
+	}
+}
+
+// cursorRow turns a cursor back into a row that can be compared with others
+func cursorRow(cursor *Cursor, key string) (memoryRow, error) {
+	row := memoryRow{comment: &Comment{ID: cursor.ID}}
+	switch key {
+	case "author":
+		row.comment.Author = cursor.Value
+	case "created":
+		createdAt, err := time.Parse(time.RFC3339, cursor.Value)
+		if err != nil {
+			return row, ErrInvalidCursor
+		}
+		row.comment.CreatedAt = createdAt
+	case "relevance":
+		rank, err := strconv.ParseFloat(cursor.Value, 32)
+		if err != nil {
+			return row, ErrInvalidCursor
+		}
+		row.rank = float32(rank)
+	case "top":
+		score, err := strconv.Atoi(cursor.Value)
+		if err != nil {
+			return row, ErrInvalidCursor
+		}
+		row.comment.Score = -score
+	case "hot":
+		hot, err := strconv.ParseFloat(cursor.Value, 64)
+		if err != nil {
+			return row, ErrInvalidCursor
+		}
+		row.hot = hot
+	}
+	return row, nil
+}
+
+// Get the page of comments matching search that follows after, using keyset
+// pagination on the sort column and id. A nil after starts from the
+// beginning. The returned cursor is nil on the last page.
+func (c MemoryCommentModel) GetAllAfter(ctx context.Context, search CommentSearch, filters Filters, after *Cursor) ([]*Comment, *Cursor, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	rows := c.store.searchRows(search)
+	if after != nil {
+		// Keep only the rows that sort after the cursor's position
+		afterRow, err := cursorRow(after, filters.sortKey())
+		if err != nil {
+			return nil, nil, err
+		}
+		order := rowOrder(filters)
+		rows = slices.DeleteFunc(rows, func(row memoryRow) bool { return order(row, afterRow) <= 0 })
+	}
+	sortRows(rows, filters)
+
+	comments := []*Comment{}
+	for _, row := range rows[:min(filters.limit(), len(rows))] {
+		comments = append(comments, row.comment)
+	}
+	if len(rows) <= filters.limit() {
+		return comments, nil, nil
+	}
+
+	last := rows[filters.limit()-1]
+	next := &Cursor{Sort: filters.Sort, Search: search.Digest(), Value: sortValue(last, filters.sortKey()), ID: last.comment.ID}
+	return comments, next, nil
+}
+
+// Get a comment and its replies, nested down to maxDepth levels below it.
+// Deleted replies stay in the tree as placeholders with their text removed.
+// The tree holds every reply, but reply counts only include those viewer
+// can see.
+func (c MemoryCommentModel) GetThread(ctx context.Context, id int64, maxDepth int, viewer Viewer) (*Comment, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	stored, found := c.store.comments[id]
+	if !found || stored.DeletedAt != nil {
+		return nil, ErrRecordNotFound
+	}
+
+	counts := c.store.replyCounts(viewer)
+	children := make(map[int64][]*Comment)
+	for _, comment := range c.store.comments {
+		if comment.ParentID != nil {
+			children[*comment.ParentID] = append(children[*comment.ParentID], comment)
+		}
+	}
+
+	root := copyComment(stored, counts[id])
+	level := []*Comment{root}
+	for depth := 0; depth < maxDepth && len(level) > 0; depth++ {
+		var next []*Comment
+		for _, parent := range level {
+			replies := children[parent.ID]
+			slices.SortFunc(replies, func(a, b *Comment) int {
+				return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID, b.ID))
+			})
+			for _, reply := range replies {
+				node := copyComment(reply, counts[reply.ID])
+				if node.DeletedAt != nil {
+					node.Content = ""
+					node.Author = ""
+				}
+				parent.Replies = append(parent.Replies, node)
+				next = append(next, node)
+			}
+		}
+		level = next
+	}
+	return root, nil
+}
+
+// Get every version of a comment, oldest first, ending with the current one
+func (c MemoryCommentModel) GetRevisions(ctx context.Context, commentID int64) ([]*Revision, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	revisions := []*Revision{}
+	for _, revision := range c.store.revisions[commentID] {
+		copied := *revision
+		revisions = append(revisions, &copied)
+	}
+	stored, found := c.store.comments[commentID]
+	if found && stored.DeletedAt == nil {
+		revisions = append(revisions, &Revision{CommentID: stored.ID, Version: stored.Version, Content: stored.Content})
+	}
+	return revisions, nil
+}
+
+// Get a specific version of a comment, which may be the current one. A
+// version in a gap has the content of the next stored revision.
+func (c MemoryCommentModel) GetRevision(ctx context.Context, commentID int64, version int32) (*Revision, error) {
+	revisions, err := c.GetRevisions(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		if revision.Version >= version {
+			revision.Version = version
+			return revision, nil
+		}
+	}
+	return nil, ErrRecordNotFound
+}
+
+// textQuery is a parsed websearch-style query: alternatives separated by
+// "or", each a list of terms that must all match. It follows the syntax of
+// websearch_to_tsquery but, unlike the english configuration, does no
+// stemming and keeps stop words.
+type textQuery [][]textTerm
+
+// textTerm is a single word or a quoted phrase, optionally negated with "-"
+type textTerm struct {
+	words  []string
+	negate bool
+}
+
+// textWords splits text into lower-cased words
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func parseTextQuery(q string) textQuery {
+	query := textQuery{nil}
+	add := func(term textTerm) {
+		query[len(query)-1] = append(query[len(query)-1], term)
+	}
+
+	for {
+		q = strings.TrimLeftFunc(q, unicode.IsSpace)
+		if q == "" {
+			break
+		}
+
+		negate := strings.HasPrefix(q, "-")
+		if negate {
+			q = q[1:]
+		}
+
+		if strings.HasPrefix(q, `"`) {
+			phrase := q[1:]
+			q = ""
+			if end := strings.IndexByte(phrase, '"'); end >= 0 {
+				phrase, q = phrase[:end], phrase[end+1:]
+			}
+			if words := textWords(phrase); len(words) > 0 {
+				add(textTerm{words: words, negate: negate})
+			}
+			continue
+		}
+
+		end := strings.IndexFunc(q, unicode.IsSpace)
+		if end < 0 {
+			end = len(q)
+		}
+		word := q[:end]
+		q = q[end:]
+		if !negate && strings.EqualFold(word, "or") {
+			if len(query[len(query)-1]) > 0 {
+				query = append(query, nil)
+			}
+			continue
+		}
+		for _, w := range textWords(word) {
+			add(textTerm{words: []string{w}, negate: negate})
+		}
+	}
+
+	return slices.DeleteFunc(query, func(terms []textTerm) bool { return len(terms) == 0 })
+}
+
+// occurrences counts where term appears in words
+func (t textTerm) occurrences(words []string) int {
+	count := 0
+	for i := 0; i+len(t.words) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(t.words)], t.words) {
+			count++
+		}
+	}
+	return count
+}
+
+// matches reports whether words satisfy any alternative of the query. A
+// query without any words matches nothing.
+func (q textQuery) matches(words []string) bool {
+	for _, terms := range q {
+		matched := true
+		for _, term := range terms {
+			if (term.occurrences(words) > 0) == term.negate {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// rank scores words by how often the query's wanted terms appear in them
+func (q textQuery) rank(words []string) float32 {
+	count := 0
+	for _, terms := range q {
+		for _, term := range terms {
+			if !term.negate {
+				count += term.occurrences(words)
+			}
+		}
+	}
+	return float32(count) / float32(len(words)+1)
+}
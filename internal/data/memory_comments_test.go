@@ -0,0 +1,125 @@
+package data
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTextQueryMatches(t *testing.T) {
+	tests := []struct {
+		query   string
+		content string
+		want    bool
+	}{
+		{"fox", "The quick brown Fox.", true},
+		{"fox dog", "the quick brown fox", false},
+		{"fox or dog", "a lazy dog", true},
+		{"fox -dog", "the fox and the dog", false},
+		{"fox -dog", "the fox alone", true},
+		{`"brown fox"`, "the quick brown fox", true},
+		{`"fox brown"`, "the quick brown fox", false},
+		{`-"brown fox"`, "a brown dog and a fox", true},
+		{`"brown fox`, "the quick brown fox", true},
+		{"or fox", "the quick brown fox", true},
+		{"fox or", "the quick brown fox", true},
+		{"", "anything", false},
+		{`"" -`, "anything", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if got := parseTextQuery(tt.query).matches(textWords(tt.content)); got != tt.want {
+				t.Errorf("matching %q: got %t, want %t", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextQueryRank(t *testing.T) {
+	query := parseTextQuery("quick -slow")
+	once := query.rank(textWords("a quick fox"))
+	twice := query.rank(textWords("a quick quick fox"))
+	diluted := query.rank(textWords("a quick fox that is rather long"))
+
+	if !(twice > once && once > diluted && diluted > 0) {
+		t.Errorf("got ranks %v, %v and %v, want them strictly decreasing and positive", twice, once, diluted)
+	}
+	if rank := query.rank(textWords("a slow fox")); rank != 0 {
+		t.Errorf("got rank %v for negated words only, want 0", rank)
+	}
+}
+
+func TestRowOrder(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := []memoryRow{
+		{comment: &Comment{ID: 4, Author: "Bob", Score: 1, CreatedAt: created}},
+		{comment: &Comment{ID: 1, Author: "Bob", Score: 5, CreatedAt: created.Add(time.Hour)}},
+		{comment: &Comment{ID: 3, Author: "Alice", Score: 1, CreatedAt: created}},
+		{comment: &Comment{ID: 2, Author: "Carol", Score: -2, CreatedAt: created.Add(-time.Hour)}},
+	}
+
+	tests := []struct {
+		sort string
+		want []int64
+	}{
+		{"id", []int64{1, 2, 3, 4}},
+		{"-id", []int64{4, 3, 2, 1}},
+		{"author", []int64{3, 1, 4, 2}},
+		{"-author", []int64{2, 1, 4, 3}},
+		{"created", []int64{2, 3, 4, 1}},
+		{"-created", []int64{1, 3, 4, 2}},
+		{"top", []int64{1, 3, 4, 2}},
+		{"-top", []int64{2, 3, 4, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			sorted := slices.Clone(rows)
+			sortRows(sorted, Filters{Sort: tt.sort, SortSafelist: commentSortSafelist})
+
+			got := []int64{}
+			for _, row := range sorted {
+				got = append(got, row.comment.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorRow(t *testing.T) {
+	row := memoryRow{
+		comment: &Comment{ID: 7, Author: "Alice", Score: 3, CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)},
+		rank:    -0.25,
+		hot:     -39000.123456789,
+	}
+
+	// A cursor built from a row must compare equal to that row
+	for _, key := range []string{"id", "author", "created", "relevance", "top", "hot"} {
+		t.Run(key, func(t *testing.T) {
+			cursor := &Cursor{Sort: key, Value: sortValue(row, key), ID: row.comment.ID}
+			got, err := cursorRow(cursor, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result := compareRows(got, row, key); result != 0 {
+				t.Errorf("cursor value %q compares %d with its row, want 0", cursor.Value, result)
+			}
+			if got.comment.ID != row.comment.ID {
+				t.Errorf("got ID %d, want %d", got.comment.ID, row.comment.ID)
+			}
+		})
+	}
+
+	t.Run("invalid values", func(t *testing.T) {
+		for _, key := range []string{"created", "relevance", "top", "hot"} {
+			_, err := cursorRow(&Cursor{Sort: key, Value: "yesterday", ID: 1}, key)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("%s: got error %v, want %v", key, err, ErrInvalidCursor)
+			}
+		}
+	})
+}
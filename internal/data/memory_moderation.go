@@ -0,0 +1,50 @@
+package data
+
+import (
+	"context"
+	"slices"
+)
+
+// Set the status of the live comments with the given ids, recording the
+// reason. The decision resolves their open reports, and approval brings back
+// comments hidden by reports. It returns the ids of the comments that were
+// found, in ascending order.
+func (c MemoryCommentModel) Moderate(ctx context.Context, ids []int64, status, reason string, moderatorID int64) ([]int64, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	moderated := []int64{}
+	for _, id := range ids {
+		stored, found := c.store.comments[id]
+		if !found || stored.DeletedAt != nil || slices.Contains(moderated, id) {
+			continue
+		}
+		stored.Status = status
+		stored.ModerationReason = reason
+		if status == CommentApproved {
+			stored.Hidden = false
+		}
+		c.store.resolveReports(id)
+		moderated = append(moderated, id)
+	}
+	slices.Sort(moderated)
+	return moderated, nil
+}
+
+// Get the live comments with a status, oldest first by default
+func (c MemoryCommentModel) GetByStatus(ctx context.Context, status string, filters Filters) ([]*Comment, Metadata, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	counts := c.store.replyCounts(Viewer{Moderator: true})
+	rows := []memoryRow{}
+	for id, stored := range c.store.comments {
+		if stored.DeletedAt == nil && stored.Status == status {
+			rows = append(rows, memoryRow{comment: copyComment(stored, counts[id])})
+		}
+	}
+	sortRows(rows, filters)
+
+	comments, metadata := pageRows(rows, filters)
+	return comments, metadata, nil
+}
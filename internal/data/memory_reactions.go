@@ -0,0 +1,87 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+)
+
+// memoryReaction identifies one user's reaction to a comment, like the
+// primary key of comment_reactions
+type memoryReaction struct {
+	commentID int64
+	userID    int64
+	emoji     string
+}
+
+// Define a MemoryReactionModel struct which keeps reactions in a MemoryStore
+type MemoryReactionModel struct {
+	store *MemoryStore
+}
+
+// deleteReactions removes the reactions to a purged comment, as the
+// foreign key cascade does
+func (s *MemoryStore) deleteReactions(commentID int64) {
+	for reaction := range s.reactions {
+		if reaction.commentID == commentID {
+			delete(s.reactions, reaction)
+		}
+	}
+}
+
+// Add a user's reaction to a comment. Adding it again changes nothing.
+func (m MemoryReactionModel) Add(ctx context.Context, commentID, userID int64, emoji string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	m.store.reactions[memoryReaction{commentID: commentID, userID: userID, emoji: emoji}] = true
+	return nil
+}
+
+// Remove a user's reaction from a comment. Removing a reaction that is not
+// there changes nothing.
+func (m MemoryReactionModel) Remove(ctx context.Context, commentID, userID int64, emoji string) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	delete(m.store.reactions, memoryReaction{commentID: commentID, userID: userID, emoji: emoji})
+	return nil
+}
+
+// Count the reactions to each of commentIDs, most popular first, flagging
+// the ones made by userID. Comments without reactions are left out.
+func (m MemoryReactionModel) Summarize(ctx context.Context, commentIDs []int64, userID int64) (map[int64][]ReactionCount, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	counts := make(map[int64]map[string]*ReactionCount)
+	for reaction := range m.store.reactions {
+		if !slices.Contains(commentIDs, reaction.commentID) {
+			continue
+		}
+		byEmoji := counts[reaction.commentID]
+		if byEmoji == nil {
+			byEmoji = make(map[string]*ReactionCount)
+			counts[reaction.commentID] = byEmoji
+		}
+		count := byEmoji[reaction.emoji]
+		if count == nil {
+			count = &ReactionCount{Emoji: reaction.emoji}
+			byEmoji[reaction.emoji] = count
+		}
+		count.Count++
+		count.ReactedByMe = count.ReactedByMe || reaction.userID == userID
+	}
+
+	summary := make(map[int64][]ReactionCount)
+	for commentID, byEmoji := range counts {
+		for _, count := range byEmoji {
+			summary[commentID] = append(summary[commentID], *count)
+		}
+		slices.SortFunc(summary[commentID], func(a, b ReactionCount) int {
+			return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Emoji, b.Emoji))
+		})
+	}
+	return summary, nil
+}
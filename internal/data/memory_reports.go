@@ -0,0 +1,142 @@
+package data
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+)
+
+// memoryReportKey identifies one user's report on a comment, like the
+// primary key of comment_reports
+type memoryReportKey struct {
+	commentID  int64
+	reporterID int64
+}
+
+// memoryReport is a stored report and whether a moderator has resolved it
+type memoryReport struct {
+	Report
+	resolved bool
+}
+
+// Define a MemoryReportModel struct which keeps reports in a MemoryStore
+type MemoryReportModel struct {
+	store *MemoryStore
+}
+
+// WithLogger returns the model unchanged; there are no queries to log
+func (m MemoryReportModel) WithLogger(logger *slog.Logger) ReportStore {
+	return m
+}
+
+// deleteReports removes the reports on a purged comment, as the foreign key
+// cascade does
+func (s *MemoryStore) deleteReports(commentID int64) {
+	for key := range s.reports {
+		if key.commentID == commentID {
+			delete(s.reports, key)
+		}
+	}
+}
+
+// resolveReports closes the open reports on a moderated comment
+func (s *MemoryStore) resolveReports(commentID int64) {
+	for key, report := range s.reports {
+		if key.commentID == commentID {
+			report.resolved = true
+		}
+	}
+}
+
+// openReports returns the open reports on a comment, newest first
+func (s *MemoryStore) openReports(commentID int64) []*Report {
+	reports := []*Report{}
+	for key, stored := range s.reports {
+		if key.commentID == commentID && !stored.resolved {
+			report := stored.Report
+			reports = append(reports, &report)
+		}
+	}
+	slices.SortFunc(reports, func(a, b *Report) int {
+		return cmp.Or(b.CreatedAt.Compare(a.CreatedAt), cmp.Compare(a.ReporterID, b.ReporterID))
+	})
+	return reports
+}
+
+// Add a report on a live comment, reporting whether it is a new one. A
+// reporter has at most one open report per comment, so reporting again
+// replaces it and does not count twice. Once hideThreshold distinct users
+// have open reports on the comment it is hidden; 0 never hides it.
+func (m MemoryReportModel) Add(ctx context.Context, report *Report, hideThreshold int) (bool, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, found := m.store.comments[report.CommentID]
+	if !found || stored.DeletedAt != nil {
+		return false, ErrRecordNotFound
+	}
+
+	key := memoryReportKey{commentID: report.CommentID, reporterID: report.ReporterID}
+	previous, reported := m.store.reports[key]
+	created := !reported || previous.resolved
+
+	report.CreatedAt = memoryNow()
+	m.store.reports[key] = &memoryReport{Report: *report}
+
+	if hideThreshold > 0 && len(m.store.openReports(report.CommentID)) >= hideThreshold {
+		stored.Hidden = true
+	}
+	return created, nil
+}
+
+// Get the live comments with open reports and a summary of those reports,
+// most reported first by default
+func (m MemoryReportModel) Summarize(ctx context.Context, filters Filters) ([]*ReportSummary, Metadata, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	counts := m.store.replyCounts(Viewer{Moderator: true})
+	summaries := []*ReportSummary{}
+	for id, stored := range m.store.comments {
+		reports := m.store.openReports(id)
+		if stored.DeletedAt != nil || len(reports) == 0 {
+			continue
+		}
+		summary := &ReportSummary{
+			Comment:        copyComment(stored, counts[id]),
+			ReportCount:    len(reports),
+			Reasons:        make(map[string]int),
+			LastReportedAt: reports[0].CreatedAt,
+			Reports:        reports,
+		}
+		for _, report := range reports {
+			summary.Reasons[report.Reason]++
+		}
+		summaries = append(summaries, summary)
+	}
+
+	key := filters.sortKey()
+	descending := filters.sortDirection() == "DESC"
+	slices.SortFunc(summaries, func(a, b *ReportSummary) int {
+		var result int
+		switch key {
+		case "reports":
+			result = cmp.Compare(a.ReportCount, b.ReportCount)
+		case "latest":
+			result = a.LastReportedAt.Compare(b.LastReportedAt)
+		default:
+			result = cmp.Compare(a.Comment.ID, b.Comment.ID)
+		}
+		if descending {
+			result = -result
+		}
+		return cmp.Or(result, cmp.Compare(a.Comment.ID, b.Comment.ID))
+	})
+
+	if filters.offset() >= len(summaries) {
+		return []*ReportSummary{}, calculateMetadata(0, filters.Page, filters.PageSize), nil
+	}
+	end := min(filters.offset()+filters.limit(), len(summaries))
+	return summaries[filters.offset():end], calculateMetadata(len(summaries), filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,56 @@
+package data
+
+import "context"
+
+// Define a MemoryNamespaceModel struct which keeps namespace settings in a
+// MemoryStore
+type MemoryNamespaceModel struct {
+	store *MemoryStore
+}
+
+// Get the settings of a namespace. A namespace that was never configured is
+// open.
+func (m MemoryNamespaceModel) Get(ctx context.Context, name string) (*Namespace, error) {
+	m.store.mu.RLock()
+	defer m.store.mu.RUnlock()
+
+	stored, found := m.store.namespaces[name]
+	if !found {
+		return &Namespace{Name: name, Status: NamespaceOpen}, nil
+	}
+	namespace := *stored
+	return &namespace, nil
+}
+
+// Set the settings of a namespace, creating it if needed
+func (m MemoryNamespaceModel) Set(ctx context.Context, namespace *Namespace) error {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	namespace.UpdatedAt = memoryNow()
+	stored := *namespace
+	m.store.namespaces[namespace.Name] = &stored
+	return nil
+}
+
+// Count the live comments the public can see on each of the targets in
+// namespace with the given ids. Every id is in the result, with 0 if it has
+// no comments.
+func (c MemoryCommentModel) CountByTarget(ctx context.Context, namespace string, ids []string) (map[string]int, error) {
+	c.store.mu.RLock()
+	defer c.store.mu.RUnlock()
+
+	counts := make(map[string]int, len(ids))
+	for _, id := range ids {
+		counts[id] = 0
+	}
+	for _, stored := range c.store.comments {
+		if stored.DeletedAt != nil || stored.Status != CommentApproved || stored.Hidden || stored.Target.Namespace != namespace {
+			continue
+		}
+		if _, wanted := counts[stored.Target.ID]; wanted {
+			counts[stored.Target.ID]++
+		}
+	}
+	return counts, nil
+}
@@ -0,0 +1,48 @@
+package data
+
+import "context"
+
+// memoryVote identifies one user's vote on a comment, like the primary key
+// of comment_votes
+type memoryVote struct {
+	commentID int64
+	userID    int64
+}
+
+// Define a MemoryVoteModel struct which keeps votes in a MemoryStore
+type MemoryVoteModel struct {
+	store *MemoryStore
+}
+
+// deleteVotes removes the votes on a purged comment, as the foreign key
+// cascade does
+func (s *MemoryStore) deleteVotes(commentID int64) {
+	for vote := range s.votes {
+		if vote.commentID == commentID {
+			delete(s.votes, vote)
+		}
+	}
+}
+
+// Set a user's vote on a live comment they can see, replacing any earlier
+// vote; a value of 0 withdraws it. The comment's score is adjusted to match
+// and returned.
+func (m MemoryVoteModel) Set(ctx context.Context, commentID int64, voter Viewer, value int) (int, error) {
+	m.store.mu.Lock()
+	defer m.store.mu.Unlock()
+
+	stored, found := m.store.comments[commentID]
+	if !found || stored.DeletedAt != nil || !voter.CanSee(stored) {
+		return 0, ErrRecordNotFound
+	}
+
+	key := memoryVote{commentID: commentID, userID: voter.UserID}
+	previous := m.store.votes[key]
+	if value == 0 {
+		delete(m.store.votes, key)
+	} else {
+		m.store.votes[key] = value
+	}
+	stored.Score += value - previous
+	return stored.Score, nil
+}
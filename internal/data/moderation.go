@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+	"victortillett.net/basic/internal/validator"
+)
+
+// Statuses of a comment. Only approved comments are public; the others are
+// seen by their author and moderators alone.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentRejected = "rejected"
+	CommentSpam     = "spam"
+)
+
+// CommentStatuses lists every comment status
+var CommentStatuses = []string{CommentPending, CommentApproved, CommentRejected, CommentSpam}
+
+// Validate a moderation decision. Rejections must say why.
+func ValidateModeration(v *validator.Validator, status, reason string) {
+	v.Check(validator.PermittedValue(status, CommentApproved, CommentRejected, CommentSpam), "status", "must be approved, rejected or spam")
+	if status == CommentRejected {
+		v.Check(reason != "", "reason", "must be provided")
+	}
+	v.Check(len(reason) <= 500, "reason", "must not be more than 500 bytes long")
+}
+
+// Set the status of the live comments with the given ids, recording the
+// moderator and the reason. The decision resolves their open reports, and
+// approval brings back comments hidden by reports. It returns the ids of
+// the comments that were found, in ascending order.
+func (c CommentModel) Moderate(ctx context.Context, ids []int64, status, reason string, moderatorID int64) ([]int64, error) {
+	defer c.logSlow("Moderate", time.Now())
+
+	query := `
+		WITH moderated AS (
+			UPDATE comments
+			SET status = $2, moderation_reason = $3, moderated_by = $4, moderated_at = now(),
+			    hidden_at = CASE WHEN $2 = 'approved' THEN NULL ELSE hidden_at END
+			WHERE id = ANY($1) AND deleted_at IS NULL
+			RETURNING id
+		), resolved AS (
+			UPDATE comment_reports
+			SET resolved = true
+			WHERE comment_id IN (SELECT id FROM moderated) AND NOT resolved
+		)
+		SELECT id FROM moderated`
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, pq.Array(ids), status, reason, moderatorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	moderated := []int64{}
+	for rows.Next() {
+		var id int64
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		moderated = append(moderated, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(moderated)
+	return moderated, nil
+}
+
+// Get the live comments with a status, oldest first by default
+func (c CommentModel) GetByStatus(ctx context.Context, status string, filters Filters) ([]*Comment, Metadata, error) {
+	defer c.logSlow("GetByStatus", time.Now())
+
+	validSortFields := map[string]string{
+		"id":      "id",
+		"created": "created_at",
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, parent_id, COALESCE(user_id, 0), created_at, updated_at, content, author, version, score, COALESCE(target_namespace, ''), COALESCE(target_id, ''), status, moderation_reason, hidden_at IS NOT NULL, deleted_at,
+		       (SELECT count(*) FROM comments r WHERE r.parent_id = comments.id)
+		FROM comments
+		WHERE status = $1 AND deleted_at IS NULL
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, validSortFields[filters.sortKey()], filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, status, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	comments := []*Comment{}
+
+	for rows.Next() {
+		var cm Comment
+		err := rows.Scan(&totalRecords, &cm.ID, &cm.ParentID, &cm.UserID, &cm.CreatedAt, &cm.UpdatedAt, &cm.Content, &cm.Author, &cm.Version, &cm.Score, &cm.Target.Namespace, &cm.Target.ID, &cm.Status, &cm.ModerationReason, &cm.Hidden, &cm.DeletedAt, &cm.ReplyCount)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		comments = append(comments, &cm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return comments, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
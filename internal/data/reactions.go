@@ -0,0 +1,80 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ReactionCount is how many users reacted to a comment with one emoji
+type ReactionCount struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+}
+
+// Define a ReactionModel struct which wraps a sql.DB connection pool
+type ReactionModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// Add a user's reaction to a comment. Adding it again changes nothing.
+func (m ReactionModel) Add(ctx context.Context, commentID, userID int64, emoji string) error {
+	query := `
+		INSERT INTO comment_reactions (comment_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, commentID, userID, emoji)
+	return err
+}
+
+// Remove a user's reaction from a comment. Removing a reaction that is not
+// there changes nothing.
+func (m ReactionModel) Remove(ctx context.Context, commentID, userID int64, emoji string) error {
+	query := `
+		DELETE FROM comment_reactions
+		WHERE comment_id = $1 AND user_id = $2 AND emoji = $3`
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, commentID, userID, emoji)
+	return err
+}
+
+// Count the reactions to each of commentIDs, most popular first, flagging
+// the ones made by userID. Comments without reactions are left out.
+func (m ReactionModel) Summarize(ctx context.Context, commentIDs []int64, userID int64) (map[int64][]ReactionCount, error) {
+	query := `
+		SELECT comment_id, emoji, count(*), bool_or(user_id = $2)
+		FROM comment_reactions
+		WHERE comment_id = ANY($1)
+		GROUP BY comment_id, emoji
+		ORDER BY comment_id, count(*) DESC, emoji COLLATE "C"`
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(commentIDs), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := make(map[int64][]ReactionCount)
+	for rows.Next() {
+		var commentID int64
+		var reaction ReactionCount
+		err := rows.Scan(&commentID, &reaction.Emoji, &reaction.Count, &reaction.ReactedByMe)
+		if err != nil {
+			return nil, err
+		}
+		summary[commentID] = append(summary[commentID], reaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
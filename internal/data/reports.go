@@ -0,0 +1,229 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lib/pq"
+	"victortillett.net/basic/internal/validator"
+)
+
+// ReportReasons are the reasons a comment can be reported for
+var ReportReasons = []string{"spam", "abuse", "harassment", "misinformation", "off_topic", "other"}
+
+// Report is one user's complaint about a comment. A report stays open until
+// a moderator decides on the comment.
+type Report struct {
+	CommentID  int64     `json:"comment_id"`
+	ReporterID int64     `json:"reporter_id"`
+	Reason     string    `json:"reason"`
+	Details    string    `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Validate a report. Reports for another reason must explain themselves.
+func ValidateReport(v *validator.Validator, report *Report) {
+	v.Check(validator.PermittedValue(report.Reason, ReportReasons...), "reason", "must be one of spam, abuse, harassment, misinformation, off_topic or other")
+	if report.Reason == "other" {
+		v.Check(report.Details != "", "details", "must be provided when the reason is other")
+	}
+	v.Check(len(report.Details) <= 500, "details", "must not be more than 500 bytes long")
+}
+
+// ReportSummary gathers the open reports on one comment for moderators
+type ReportSummary struct {
+	Comment        *Comment       `json:"comment"`
+	ReportCount    int            `json:"report_count"`
+	Reasons        map[string]int `json:"reasons"`
+	LastReportedAt time.Time      `json:"last_reported_at"`
+	Reports        []*Report      `json:"reports"`
+}
+
+// Define a ReportModel struct which wraps a sql.DB connection pool. Queries
+// are cancelled after QueryTimeout and Logger, when set, is warned about slow
+// ones.
+type ReportModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+	Logger       *slog.Logger
+}
+
+// WithLogger returns a copy of the model that logs through logger
+func (m ReportModel) WithLogger(logger *slog.Logger) ReportStore {
+	m.Logger = logger
+	return m
+}
+
+// logSlow warns about a query begun at start that ran for too long
+func (m ReportModel) logSlow(method string, start time.Time) {
+	if elapsed := time.Since(start); m.Logger != nil && elapsed > slowQueryThreshold {
+		m.Logger.Warn("slow query", "method", "ReportModel."+method, "duration", elapsed)
+	}
+}
+
+// Add a report on a live comment, reporting whether it is a new one. A
+// reporter has at most one open report per comment, so reporting again
+// replaces it and does not count twice. Once hideThreshold distinct users
+// have open reports on the comment it is hidden; 0 never hides it.
+func (m ReportModel) Add(ctx context.Context, report *Report, hideThreshold int) (bool, error) {
+	defer m.logSlow("Add", time.Now())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Locking the comment serializes reports on it, so the threshold is
+	// checked against every report
+	query := `
+		SELECT id
+		FROM comments
+		WHERE id = $1 AND deleted_at IS NULL
+		FOR NO KEY UPDATE`
+	err = tx.QueryRowContext(ctx, query, report.CommentID).Scan(&report.CommentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+
+	var created, resolved bool
+	query = `
+		SELECT resolved
+		FROM comment_reports
+		WHERE comment_id = $1 AND reporter_id = $2`
+	err = tx.QueryRowContext(ctx, query, report.CommentID, report.ReporterID).Scan(&resolved)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		created = true
+	case err != nil:
+		return false, err
+	default:
+		created = resolved
+	}
+
+	query = `
+		INSERT INTO comment_reports (comment_id, reporter_id, reason, details)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (comment_id, reporter_id) DO UPDATE
+		SET reason = EXCLUDED.reason, details = EXCLUDED.details, resolved = false, created_at = now()
+		RETURNING created_at`
+	err = tx.QueryRowContext(ctx, query, report.CommentID, report.ReporterID, report.Reason, report.Details).Scan(&report.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+
+	if hideThreshold > 0 {
+		query = `
+			UPDATE comments
+			SET hidden_at = now()
+			WHERE id = $1 AND hidden_at IS NULL
+			AND (SELECT count(*) FROM comment_reports WHERE comment_id = $1 AND NOT resolved) >= $2`
+		_, err = tx.ExecContext(ctx, query, report.CommentID, hideThreshold)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	return created, nil
+}
+
+// Get the live comments with open reports and a summary of those reports,
+// most reported first by default
+func (m ReportModel) Summarize(ctx context.Context, filters Filters) ([]*ReportSummary, Metadata, error) {
+	defer m.logSlow("Summarize", time.Now())
+
+	validSortFields := map[string]string{
+		"id":      "c.id",
+		"reports": "r.report_count",
+		"latest":  "r.last_reported_at",
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), c.id, c.parent_id, COALESCE(c.user_id, 0), c.created_at, c.updated_at, c.content, c.author, c.version, c.score,
+		       COALESCE(c.target_namespace, ''), COALESCE(c.target_id, ''), c.status, c.moderation_reason, c.hidden_at IS NOT NULL, c.deleted_at,
+		       (SELECT count(*) FROM comments rc WHERE rc.parent_id = c.id),
+		       r.report_count, r.last_reported_at
+		FROM (
+			SELECT comment_id, count(*) AS report_count, max(created_at) AS last_reported_at
+			FROM comment_reports
+			WHERE NOT resolved
+			GROUP BY comment_id
+		) r
+		INNER JOIN comments c ON c.id = r.comment_id
+		WHERE c.deleted_at IS NULL
+		ORDER BY %s %s, c.id ASC
+		LIMIT $1 OFFSET $2`, validSortFields[filters.sortKey()], filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	summaries := []*ReportSummary{}
+	byComment := make(map[int64]*ReportSummary)
+	ids := []int64{}
+
+	for rows.Next() {
+		var cm Comment
+		summary := &ReportSummary{Comment: &cm, Reasons: make(map[string]int), Reports: []*Report{}}
+		err := rows.Scan(&totalRecords, &cm.ID, &cm.ParentID, &cm.UserID, &cm.CreatedAt, &cm.UpdatedAt, &cm.Content, &cm.Author, &cm.Version, &cm.Score, &cm.Target.Namespace, &cm.Target.ID, &cm.Status, &cm.ModerationReason, &cm.Hidden, &cm.DeletedAt, &cm.ReplyCount, &summary.ReportCount, &summary.LastReportedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		summaries = append(summaries, summary)
+		byComment[cm.ID] = summary
+		ids = append(ids, cm.ID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+	rows.Close()
+
+	query = `
+		SELECT comment_id, reporter_id, reason, details, created_at
+		FROM comment_reports
+		WHERE comment_id = ANY($1) AND NOT resolved
+		ORDER BY comment_id, created_at DESC, reporter_id`
+	rows, err = m.DB.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(&report.CommentID, &report.ReporterID, &report.Reason, &report.Details, &report.CreatedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		summary := byComment[report.CommentID]
+		summary.Reasons[report.Reason]++
+		summary.Reports = append(summary.Reports, &report)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return summaries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,96 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define a Revision struct to represent one version of a comment's content.
+// ReplacedAt is nil for the version that is current.
+//
+// Deleting and restoring a comment bumps its version without changing its
+// content, so version numbers have gaps. A stored revision carries the last
+// version its content was current at.
+type Revision struct {
+	CommentID  int64      `json:"comment_id"`
+	Version    int32      `json:"version"`
+	Content    string     `json:"content"`
+	ReplacedAt *time.Time `json:"replaced_at"`
+}
+
+// Get every version of a comment, oldest first, ending with the current one
+func (c CommentModel) GetRevisions(ctx context.Context, commentID int64) ([]*Revision, error) {
+	defer c.logSlow("GetRevisions", time.Now())
+
+	query := `
+		SELECT comment_id, version, content, replaced_at
+		FROM comment_revisions
+		WHERE comment_id = $1
+		UNION ALL
+		SELECT id, version, content, NULL
+		FROM comments
+		WHERE id = $1 AND deleted_at IS NULL
+		ORDER BY version`
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*Revision{}
+	for rows.Next() {
+		var revision Revision
+		err := rows.Scan(&revision.CommentID, &revision.Version, &revision.Content, &revision.ReplacedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, &revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// Get a specific version of a comment, which may be the current one. A
+// version in a gap has the content of the next stored revision, since that
+// content was current until then.
+func (c CommentModel) GetRevision(ctx context.Context, commentID int64, version int32) (*Revision, error) {
+	defer c.logSlow("GetRevision", time.Now())
+
+	query := `
+		SELECT comment_id, content, replaced_at
+		FROM (
+			SELECT comment_id, version, content, replaced_at
+			FROM comment_revisions
+			WHERE comment_id = $1 AND version >= $2
+			UNION ALL
+			SELECT id, version, content, NULL
+			FROM comments
+			WHERE id = $1 AND version >= $2 AND deleted_at IS NULL
+		) AS versions
+		ORDER BY version
+		LIMIT 1`
+	revision := Revision{Version: version}
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+	err := c.DB.QueryRowContext(ctx, query, commentID, version).Scan(
+		&revision.CommentID,
+		&revision.Content,
+		&revision.ReplacedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &revision, nil
+}
@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+)
+
+// CommentStore is implemented by every comment storage backend
+type CommentStore interface {
+	Insert(ctx context.Context, comment *Comment) error
+	Get(ctx context.Context, id int64, viewer Viewer) (*Comment, error)
+	Update(ctx context.Context, comment *Comment) error
+	Delete(ctx context.Context, id int64) error
+	Restore(ctx context.Context, id int64) error
+	PurgeDeleted(ctx context.Context, olderThan time.Duration) (int64, error)
+	GetAll(ctx context.Context, search CommentSearch, filters Filters) ([]*Comment, Metadata, error)
+	GetAllAfter(ctx context.Context, search CommentSearch, filters Filters, after *Cursor) ([]*Comment, *Cursor, error)
+	GetDeleted(ctx context.Context, filters Filters) ([]*Comment, Metadata, error)
+	GetThread(ctx context.Context, id int64, maxDepth int, viewer Viewer) (*Comment, error)
+	CountByTarget(ctx context.Context, namespace string, ids []string) (map[string]int, error)
+	Moderate(ctx context.Context, ids []int64, status, reason string, moderatorID int64) ([]int64, error)
+	GetByStatus(ctx context.Context, status string, filters Filters) ([]*Comment, Metadata, error)
+	GetRevisions(ctx context.Context, commentID int64) ([]*Revision, error)
+	GetRevision(ctx context.Context, commentID int64, version int32) (*Revision, error)
+	WithLogger(logger *slog.Logger) CommentStore
+}
+
+// UserStore is implemented by every user storage backend
+type UserStore interface {
+	Insert(ctx context.Context, user *User, codes ...string) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Update(ctx context.Context, user *User) error
+	GetForToken(ctx context.Context, tokenScope, tokenPlaintext string) (*User, error)
+}
+
+// TokenStore is implemented by every token storage backend
+type TokenStore interface {
+	New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error)
+	Insert(ctx context.Context, token *Token) error
+	DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+}
+
+// PermissionStore is implemented by every permission storage backend
+type PermissionStore interface {
+	GetAllForUser(ctx context.Context, userID int64) (Permissions, error)
+	AddForUser(ctx context.Context, userID int64, codes ...string) error
+}
+
+// ReactionStore is implemented by every reaction storage backend
+type ReactionStore interface {
+	Add(ctx context.Context, commentID, userID int64, emoji string) error
+	Remove(ctx context.Context, commentID, userID int64, emoji string) error
+	Summarize(ctx context.Context, commentIDs []int64, userID int64) (map[int64][]ReactionCount, error)
+}
+
+// VoteStore is implemented by every vote storage backend
+type VoteStore interface {
+	Set(ctx context.Context, commentID int64, voter Viewer, value int) (int, error)
+}
+
+// NamespaceStore is implemented by every namespace settings storage backend
+type NamespaceStore interface {
+	Get(ctx context.Context, name string) (*Namespace, error)
+	Set(ctx context.Context, namespace *Namespace) error
+}
+
+// ReportStore is implemented by every report storage backend
+type ReportStore interface {
+	Add(ctx context.Context, report *Report, hideThreshold int) (bool, error)
+	Summarize(ctx context.Context, filters Filters) ([]*ReportSummary, Metadata, error)
+	WithLogger(logger *slog.Logger) ReportStore
+}
+
+// Models bundles the stores of a single backend
+type Models struct {
+	Comments    CommentStore
+	Users       UserStore
+	Tokens      TokenStore
+	Permissions PermissionStore
+	Reactions   ReactionStore
+	Votes       VoteStore
+	Namespaces  NamespaceStore
+	Reports     ReportStore
+}
+
+// NewPostgresModels returns stores backed by the PostgreSQL pool db. Every
+// query is cancelled after queryTimeout, or sooner if its context ends.
+func NewPostgresModels(db *sql.DB, queryTimeout time.Duration) Models {
+	return Models{
+		Comments:    CommentModel{DB: db, QueryTimeout: queryTimeout},
+		Users:       UserModel{DB: db, QueryTimeout: queryTimeout},
+		Tokens:      TokenModel{DB: db, QueryTimeout: queryTimeout},
+		Permissions: PermissionModel{DB: db, QueryTimeout: queryTimeout},
+		Reactions:   ReactionModel{DB: db, QueryTimeout: queryTimeout},
+		Votes:       VoteModel{DB: db, QueryTimeout: queryTimeout},
+		Namespaces:  NamespaceModel{DB: db, QueryTimeout: queryTimeout},
+		Reports:     ReportModel{DB: db, QueryTimeout: queryTimeout},
+	}
+}
+
+// NewMemoryModels returns stores that keep everything in process memory.
+// Their operations never block, so they ignore the contexts they are given.
+// They share one MemoryStore so that, for example, token lookups can see
+// users.
+func NewMemoryModels() Models {
+	store := NewMemoryStore()
+	return Models{
+		Comments:    MemoryCommentModel{store: store},
+		Users:       MemoryUserModel{store: store},
+		Tokens:      MemoryTokenModel{store: store},
+		Permissions: MemoryPermissionModel{store: store},
+		Reactions:   MemoryReactionModel{store: store},
+		Votes:       MemoryVoteModel{store: store},
+		Namespaces:  MemoryNamespaceModel{store: store},
+		Reports:     MemoryReportModel{store: store},
+	}
+}
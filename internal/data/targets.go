@@ -0,0 +1,143 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+	"victortillett.net/basic/internal/validator"
+)
+
+// Target is the external resource a comment is about, such as an article or
+// a ticket, identified by a namespace and the resource's id within it. The
+// zero Target means the comment is not attached to anything.
+type Target struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+}
+
+var (
+	// NamespaceRX matches namespace names, which appear in URL paths
+	NamespaceRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	// TargetIDRX matches resource ids, which must not contain a slash
+	TargetIDRX = regexp.MustCompile(`^[A-Za-z0-9._:~-]{1,100}$`)
+)
+
+// IsZero reports whether the target is unset
+func (t Target) IsZero() bool {
+	return t == Target{}
+}
+
+// Validate a target's namespace and resource id
+func ValidateTarget(v *validator.Validator, target Target) {
+	v.Check(validator.Matches(target.Namespace, NamespaceRX), "target.namespace", "must be 1 to 32 lower-case letters, digits, '-' or '_'")
+	v.Check(validator.Matches(target.ID, TargetIDRX), "target.id", "must be 1 to 100 letters, digits or any of . _ : ~ -")
+}
+
+// Statuses of a namespace. Open namespaces accept comments and replies,
+// closed ones only replies to existing comments, and locked ones nothing
+// except from moderators.
+const (
+	NamespaceOpen   = "open"
+	NamespaceClosed = "closed"
+	NamespaceLocked = "locked"
+)
+
+// Namespace holds the settings shared by every target in a namespace.
+// Comments on a premoderated namespace wait for approval. UpdatedAt is zero
+// for a namespace that has never been configured.
+type Namespace struct {
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Premoderate bool      `json:"premoderate"`
+	UpdatedAt   time.Time `json:"updated_at,omitzero"`
+}
+
+// Validate the namespace settings
+func ValidateNamespace(v *validator.Validator, namespace *Namespace) {
+	v.Check(validator.Matches(namespace.Name, NamespaceRX), "name", "must be 1 to 32 lower-case letters, digits, '-' or '_'")
+	v.Check(validator.PermittedValue(namespace.Status, NamespaceOpen, NamespaceClosed, NamespaceLocked), "status", "must be open, closed or locked")
+}
+
+// Define a NamespaceModel struct which wraps a sql.DB connection pool
+type NamespaceModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// Get the settings of a namespace. A namespace that was never configured is
+// open.
+func (m NamespaceModel) Get(ctx context.Context, name string) (*Namespace, error) {
+	query := `
+		SELECT name, status, premoderate, updated_at
+		FROM target_namespaces
+		WHERE name = $1`
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var namespace Namespace
+	err := m.DB.QueryRowContext(ctx, query, name).Scan(&namespace.Name, &namespace.Status, &namespace.Premoderate, &namespace.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &Namespace{Name: name, Status: NamespaceOpen}, nil
+		default:
+			return nil, err
+		}
+	}
+	return &namespace, nil
+}
+
+// Set the settings of a namespace, creating it if needed
+func (m NamespaceModel) Set(ctx context.Context, namespace *Namespace) error {
+	query := `
+		INSERT INTO target_namespaces (name, status, premoderate)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET status = EXCLUDED.status, premoderate = EXCLUDED.premoderate, updated_at = now()
+		RETURNING updated_at`
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+	return m.DB.QueryRowContext(ctx, query, namespace.Name, namespace.Status, namespace.Premoderate).Scan(&namespace.UpdatedAt)
+}
+
+// Count the live comments the public can see on each of the targets in
+// namespace with the given ids. Every id is in the result, with 0 if it has
+// no comments.
+func (c CommentModel) CountByTarget(ctx context.Context, namespace string, ids []string) (map[string]int, error) {
+	defer c.logSlow("CountByTarget", time.Now())
+
+	query := `
+		SELECT target_id, count(*)
+		FROM comments
+		WHERE target_namespace = $1 AND target_id = ANY($2) AND deleted_at IS NULL AND status = 'approved' AND hidden_at IS NULL
+		GROUP BY target_id`
+	ctx, cancel := context.WithTimeout(ctx, c.QueryTimeout)
+	defer cancel()
+
+	rows, err := c.DB.QueryContext(ctx, query, namespace, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int, len(ids))
+	for _, id := range ids {
+		counts[id] = 0
+	}
+	for rows.Next() {
+		var id string
+		var count int
+		err := rows.Scan(&id, &count)
+		if err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
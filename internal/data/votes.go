@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"victortillett.net/basic/internal/validator"
+)
+
+// Vote is a user's vote on a comment and the score it left the comment with
+type Vote struct {
+	Value int `json:"value"`
+	Score int `json:"score"`
+}
+
+// Validate a vote value: 1 is an upvote and -1 a downvote
+func ValidateVote(v *validator.Validator, value int) {
+	v.Check(value == 1 || value == -1, "value", "must be 1 or -1")
+}
+
+// Define a VoteModel struct which wraps a sql.DB connection pool
+type VoteModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+// Set a user's vote on a live comment they can see, replacing any earlier
+// vote; a value of 0 withdraws it. The comment's score is adjusted in the
+// same transaction and returned.
+func (m VoteModel) Set(ctx context.Context, commentID int64, voter Viewer, value int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Locking the comment serializes votes on it, so no change to the score
+	// is lost. Edits take the same lock only briefly.
+	query := `
+		SELECT id
+		FROM comments
+		WHERE id = $1 AND deleted_at IS NULL
+		AND ((status = 'approved' AND hidden_at IS NULL) OR $2 OR user_id = $3)
+		FOR NO KEY UPDATE`
+	err = tx.QueryRowContext(ctx, query, commentID, voter.Moderator, voter.UserID).Scan(&commentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	var previous int
+	query = `
+		SELECT value
+		FROM comment_votes
+		WHERE comment_id = $1 AND user_id = $2`
+	err = tx.QueryRowContext(ctx, query, commentID, voter.UserID).Scan(&previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	if value == 0 {
+		query = `
+			DELETE FROM comment_votes
+			WHERE comment_id = $1 AND user_id = $2`
+		_, err = tx.ExecContext(ctx, query, commentID, voter.UserID)
+	} else {
+		query = `
+			INSERT INTO comment_votes (comment_id, user_id, value)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (comment_id, user_id) DO UPDATE SET value = EXCLUDED.value, created_at = now()`
+		_, err = tx.ExecContext(ctx, query, commentID, voter.UserID, value)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var score int
+	query = `
+		UPDATE comments
+		SET score = score + $2
+		WHERE id = $1
+		RETURNING score`
+	err = tx.QueryRowContext(ctx, query, commentID, value-previous).Scan(&score)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	return score, nil
+}
@@ -0,0 +1,79 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// texts rebuilds both inputs from ops: the old text from the equal and
+// deleted runs, the new one from the equal and inserted runs
+func texts(ops []Op) (string, string) {
+	var a, b strings.Builder
+	for _, op := range ops {
+		if op.Op != OpInsert {
+			a.WriteString(op.Text)
+		}
+		if op.Op != OpDelete {
+			b.WriteString(op.Text)
+		}
+	}
+	return a.String(), b.String()
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"both empty", "", "", []Op{}},
+		{"identical", "the quick fox", "the quick fox", []Op{{OpEqual, "the quick fox"}}},
+		{"from empty", "", "hello world", []Op{{OpInsert, "hello world"}}},
+		{"to empty", "hello world", "", []Op{{OpDelete, "hello world"}}},
+		{"insert only", "the fox", "the quick fox", []Op{{OpEqual, "the "}, {OpInsert, "quick "}, {OpEqual, "fox"}}},
+		{"delete only", "the quick fox", "the fox", []Op{{OpEqual, "the "}, {OpDelete, "quick "}, {OpEqual, "fox"}}},
+		{"replace", "the quick fox", "the slow fox", []Op{{OpEqual, "the "}, {OpDelete, "quick"}, {OpInsert, "slow"}, {OpEqual, " fox"}}},
+		{"whitespace change", "a b", "a  b", []Op{{OpEqual, "a"}, {OpDelete, " "}, {OpInsert, "  "}, {OpEqual, "b"}}},
+		{"multi-byte", "café au lait", "café noir", []Op{{OpEqual, "café "}, {OpDelete, "au lait"}, {OpInsert, "noir"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Words(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if a, b := texts(got); a != tt.a || b != tt.b {
+				t.Errorf("ops rebuild %q and %q, want %q and %q", a, b, tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"both empty", "", "", []Op{}},
+		{"identical", "one\ntwo\n", "one\ntwo\n", []Op{{OpEqual, "one\ntwo\n"}}},
+		{"insert only", "one\nthree\n", "one\ntwo\nthree\n", []Op{{OpEqual, "one\n"}, {OpInsert, "two\n"}, {OpEqual, "three\n"}}},
+		{"delete only", "one\ntwo\nthree\n", "one\nthree\n", []Op{{OpEqual, "one\n"}, {OpDelete, "two\n"}, {OpEqual, "three\n"}}},
+		{"changed line", "one\ntwo\n", "one\n2\n", []Op{{OpEqual, "one\n"}, {OpDelete, "two\n"}, {OpInsert, "2\n"}}},
+		{"missing final newline", "one\ntwo", "one\ntwo\n", []Op{{OpEqual, "one\n"}, {OpDelete, "two"}, {OpInsert, "two\n"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Lines(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if a, b := texts(got); a != tt.a || b != tt.b {
+				t.Errorf("ops rebuild %q and %q, want %q and %q", a, b, tt.a, tt.b)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// testFiles builds a migration file system from alternating names and contents
+func testFiles(namesAndContents ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i := 0; i < len(namesAndContents); i += 2 {
+		fsys[namesAndContents[i]] = &fstest.MapFile{Data: []byte(namesAndContents[i+1])}
+	}
+	return fsys
+}
+
+func TestLoad(t *testing.T) {
+	fsys := testFiles(
+		"000010_add_index.up.sql", "CREATE INDEX",
+		"000002_create_things.up.sql", "CREATE TABLE",
+		"000002_create_things.down.sql", "DROP TABLE",
+		"3_no_padding_needed.up.sql", "ALTER TABLE",
+		"README.md", "not a migration",
+	)
+	fsys["subdirectory/000099_ignored.up.sql"] = &fstest.MapFile{Data: []byte("SELECT 1")}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Migration{
+		{Version: 2, Name: "create_things", Up: "CREATE TABLE", Down: "DROP TABLE"},
+		{Version: 3, Name: "no_padding_needed", Up: "ALTER TABLE"},
+		{Version: 10, Name: "add_index", Up: "CREATE INDEX"},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("got %d migrations, want %d: %+v", len(migrations), len(want), migrations)
+	}
+	for i := range want {
+		if migrations[i] != want[i] {
+			t.Errorf("migration %d: got %+v, want %+v", i, migrations[i], want[i])
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{"neither up nor down", testFiles("000001_things.sql", "SELECT 1"), "must end in .up.sql or .down.sql"},
+		{"no version", testFiles("things.up.sql", "SELECT 1"), "must start with a positive version"},
+		{"version zero", testFiles("000000_things.up.sql", "SELECT 1"), "must start with a positive version"},
+		{"no name separator", testFiles("000001.up.sql", "SELECT 1"), "must start with a positive version"},
+		{"mismatched names", testFiles("000001_things.up.sql", "SELECT 1", "000001_stuff.down.sql", "SELECT 1"), "is named both"},
+		{"duplicate version", testFiles("000001_things.up.sql", "SELECT 1", "1_things.up.sql", "SELECT 2"), "is defined twice"},
+		{"missing up file", testFiles("000001_things.down.sql", "SELECT 1"), "has no up file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.fsys)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPendingAndLatest(t *testing.T) {
+	migrator, err := New(nil, testFiles(
+		"000003_c.up.sql", "SELECT 3",
+		"000001_a.up.sql", "SELECT 1",
+		"000002_b.up.sql", "SELECT 2",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if latest := migrator.Latest(); latest != 3 {
+		t.Errorf("got latest version %d, want 3", latest)
+	}
+
+	tests := []struct {
+		version int64
+		want    []int64
+	}{
+		{0, []int64{1, 2, 3}},
+		{1, []int64{2, 3}},
+		{3, []int64{}},
+		{7, []int64{}},
+	}
+	for _, tt := range tests {
+		got := []int64{}
+		for _, migration := range migrator.Pending(tt.version) {
+			got = append(got, migration.Version)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("pending after %d: got %v, want %v", tt.version, got, tt.want)
+		}
+	}
+
+	empty, err := New(nil, fstest.MapFS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := empty.Latest(); latest != 0 {
+		t.Errorf("got latest version %d without migrations, want 0", latest)
+	}
+}
+
+// Forcing a version no migration has fails before touching the database
+func TestForceUnknownVersion(t *testing.T) {
+	migrator, err := New(nil, testFiles("000001_a.up.sql", "SELECT 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrator.Force(context.Background(), 2)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownVersion)
+	}
+}
+
+// newTestDB returns a single connection to the integration database named by
+// COMMENTS_TEST_DB_DSN, working in an empty schema of its own so that the
+// real schema is left alone
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("COMMENTS_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("COMMENTS_TEST_DB_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// The search path below is set on the one connection
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, statement := range []string{
+		"DROP SCHEMA IF EXISTS migrate_test CASCADE",
+		"CREATE SCHEMA migrate_test",
+		"SET search_path TO migrate_test",
+	} {
+		_, err = db.ExecContext(ctx, statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { db.Exec("DROP SCHEMA IF EXISTS migrate_test CASCADE") })
+	return db
+}
+
+// tableExists reports whether table is in the test schema
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	t.Helper()
+	var exists bool
+	err := db.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, "migrate_test."+table).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func checkVersion(t *testing.T, migrator *Migrator, wantVersion int64, wantDirty bool) {
+	t.Helper()
+	version, dirty, err := migrator.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != wantVersion || dirty != wantDirty {
+		t.Errorf("got version %d (dirty %t), want %d (dirty %t)", version, dirty, wantVersion, wantDirty)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	files := testFiles(
+		"000001_create_a.up.sql", "CREATE TABLE a (id int)",
+		"000001_create_a.down.sql", "DROP TABLE a",
+		"000002_create_b.up.sql", "CREATE TABLE b (id int)",
+		"000002_create_b.down.sql", "DROP TABLE b",
+	)
+	migrator, err := New(db, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, migrator, 0, false)
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != 2 || !tableExists(t, db, "a") || !tableExists(t, db, "b") {
+		t.Errorf("got %d migrations applied, want both tables created", applied)
+	}
+	checkVersion(t, migrator, 2, false)
+
+	applied, err = migrator.Up(ctx)
+	if err != nil || applied != 0 {
+		t.Errorf("got %d migrations applied again (error %v), want none", applied, err)
+	}
+
+	// Down reverts the newest migration first
+	reverted, err := migrator.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted != 1 || !tableExists(t, db, "a") || tableExists(t, db, "b") {
+		t.Errorf("got %d reverted, want only table b dropped", reverted)
+	}
+	checkVersion(t, migrator, 1, false)
+
+	reverted, err = migrator.Down(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted != 1 || tableExists(t, db, "a") {
+		t.Errorf("got %d reverted, want 1 and table a dropped", reverted)
+	}
+	checkVersion(t, migrator, 0, false)
+
+	// Force records a version without running anything
+	err = migrator.Force(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, migrator, 2, false)
+	if tableExists(t, db, "a") {
+		t.Error("force ran a migration")
+	}
+	err = migrator.Force(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, migrator, 0, false)
+}
+
+func TestMigratorFailure(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	migrator, err := New(db, testFiles(
+		"000001_create_a.up.sql", "CREATE TABLE a (id int)",
+		"000002_broken.up.sql", "CREATE TABLE b (id int); SELECT 1/0",
+		"000003_create_c.up.sql", "CREATE TABLE c (id int)",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err == nil || !strings.Contains(err.Error(), "migration 2_broken") {
+		t.Fatalf("got error %v, want the broken migration to fail", err)
+	}
+	if applied != 1 {
+		t.Errorf("got %d migrations applied, want 1", applied)
+	}
+
+	// The failed migration was rolled back but its version is left dirty
+	if tableExists(t, db, "b") || tableExists(t, db, "c") {
+		t.Error("got tables from the failed migration or after it")
+	}
+	checkVersion(t, migrator, 2, true)
+
+	_, err = migrator.Up(ctx)
+	if !errors.Is(err, ErrDirty) {
+		t.Errorf("got error %v running up on a dirty database, want %v", err, ErrDirty)
+	}
+	_, err = migrator.Down(ctx, 1)
+	if !errors.Is(err, ErrDirty) {
+		t.Errorf("got error %v running down on a dirty database, want %v", err, ErrDirty)
+	}
+
+	err = migrator.Force(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVersion(t, migrator, 1, false)
+}
+
+// Down cannot revert a version it has no migration for
+func TestMigratorDownUnknownVersion(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	newer, err := New(db, testFiles(
+		"000001_create_a.up.sql", "CREATE TABLE a (id int)",
+		"000002_create_b.up.sql", "CREATE TABLE b (id int)",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newer.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	older, err := New(db, testFiles(
+		"000001_create_a.up.sql", "CREATE TABLE a (id int)",
+		"000001_create_a.down.sql", "DROP TABLE a",
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = older.Down(ctx, 1)
+	if !errors.Is(err, ErrUnknownVersion) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownVersion)
+	}
+}
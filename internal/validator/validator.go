@@ -1,5 +1,12 @@
 package validator
 
+import (
+	"regexp"
+	"slices"
+)
+
+var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
 type Validator struct {
 	Errors map[string]string
 }
@@ -24,3 +31,13 @@ func (v *Validator) Check(ok bool, key string, message string) {
 		v.AddError(key, message)
 	}
 }
+
+// Matches reports whether value matches the regular expression rx
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+// PermittedValue reports whether value is one of permittedValues
+func PermittedValue[T comparable](value T, permittedValues ...T) bool {
+	return slices.Contains(permittedValues, value)
+}
@@ -0,0 +1,4 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES comments (id) ON DELETE CASCADE;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted boolean NOT NULL DEFAULT false;
+
+CREATE INDEX IF NOT EXISTS comments_parent_id_idx ON comments (parent_id);
@@ -0,0 +1,4 @@
+-- Comments written before accounts existed keep their author text and a NULL user_id
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS comments_user_id_idx ON comments (user_id);
@@ -0,0 +1,7 @@
+ALTER TABLE comments
+    ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector('english', content)) STORED;
+
+CREATE INDEX IF NOT EXISTS comments_search_vector_idx ON comments USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS comments_author_idx ON comments (author text_pattern_ops);
+CREATE INDEX IF NOT EXISTS comments_created_at_idx ON comments (created_at);
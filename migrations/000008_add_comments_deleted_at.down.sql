@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS comments_deleted_at_idx;
+
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted boolean NOT NULL DEFAULT false;
+
+UPDATE comments SET deleted = true WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,8 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+-- Carry over comments tombstoned by the old deleted flag
+UPDATE comments SET deleted_at = now() WHERE deleted;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS deleted;
+
+CREATE INDEX IF NOT EXISTS comments_deleted_at_idx ON comments (deleted_at) WHERE deleted_at IS NOT NULL;
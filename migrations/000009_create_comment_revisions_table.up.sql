@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    version integer NOT NULL,
+    content text NOT NULL,
+    replaced_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (comment_id, version)
+);
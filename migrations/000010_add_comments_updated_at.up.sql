@@ -0,0 +1,6 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS updated_at timestamp(0) with time zone;
+
+UPDATE comments SET updated_at = created_at WHERE updated_at IS NULL;
+
+ALTER TABLE comments ALTER COLUMN updated_at SET DEFAULT now();
+ALTER TABLE comments ALTER COLUMN updated_at SET NOT NULL;
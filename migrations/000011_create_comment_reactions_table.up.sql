@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS comment_reactions (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    emoji text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (comment_id, user_id, emoji)
+);
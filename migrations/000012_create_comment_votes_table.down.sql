@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS comments_score_idx;
+
+DROP TABLE IF EXISTS comment_votes;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS score;
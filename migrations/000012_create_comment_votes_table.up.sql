@@ -0,0 +1,12 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS score integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS comment_votes (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    value smallint NOT NULL CHECK (value IN (-1, 1)),
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (comment_id, user_id)
+);
+
+-- Matches the sort=top order, which negates the score to put the best first
+CREATE INDEX IF NOT EXISTS comments_score_idx ON comments ((-score), id) WHERE deleted_at IS NULL;
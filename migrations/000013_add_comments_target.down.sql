@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS target_namespaces;
+DROP INDEX IF EXISTS comments_target_idx;
+ALTER TABLE comments DROP CONSTRAINT IF EXISTS comments_target_check;
+ALTER TABLE comments DROP COLUMN IF EXISTS target_id;
+ALTER TABLE comments DROP COLUMN IF EXISTS target_namespace;
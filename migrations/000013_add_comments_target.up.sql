@@ -0,0 +1,17 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS target_namespace text;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS target_id text;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'comments_target_check' AND conrelid = 'comments'::regclass) THEN
+        ALTER TABLE comments ADD CONSTRAINT comments_target_check CHECK ((target_namespace IS NULL) = (target_id IS NULL));
+    END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS comments_target_idx ON comments (target_namespace, target_id, id) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS target_namespaces (
+    name text PRIMARY KEY,
+    status text NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'closed', 'locked')),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT now()
+);
@@ -0,0 +1,8 @@
+ALTER TABLE target_namespaces DROP COLUMN IF EXISTS premoderate;
+
+DROP INDEX IF EXISTS comments_status_idx;
+
+ALTER TABLE comments DROP COLUMN IF EXISTS moderated_at;
+ALTER TABLE comments DROP COLUMN IF EXISTS moderated_by;
+ALTER TABLE comments DROP COLUMN IF EXISTS moderation_reason;
+ALTER TABLE comments DROP COLUMN IF EXISTS status;
@@ -0,0 +1,9 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'approved' CHECK (status IN ('pending', 'approved', 'rejected', 'spam'));
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderation_reason text NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderated_by bigint REFERENCES users ON DELETE SET NULL;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS moderated_at timestamp(0) with time zone;
+
+-- The moderation queue lists comments by status, oldest first
+CREATE INDEX IF NOT EXISTS comments_status_idx ON comments (status, id) WHERE deleted_at IS NULL AND status <> 'approved';
+
+ALTER TABLE target_namespaces ADD COLUMN IF NOT EXISTS premoderate boolean NOT NULL DEFAULT false;
@@ -0,0 +1,14 @@
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS hidden_at timestamp(0) with time zone;
+
+CREATE TABLE IF NOT EXISTS comment_reports (
+    comment_id bigint NOT NULL REFERENCES comments ON DELETE CASCADE,
+    reporter_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    reason text NOT NULL CHECK (reason IN ('spam', 'abuse', 'harassment', 'misinformation', 'off_topic', 'other')),
+    details text NOT NULL DEFAULT '',
+    resolved boolean NOT NULL DEFAULT false,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (comment_id, reporter_id)
+);
+
+-- Only open reports count towards hiding and show up for moderators
+CREATE INDEX IF NOT EXISTS comment_reports_open_idx ON comment_reports (comment_id) WHERE NOT resolved;
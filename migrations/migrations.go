@@ -0,0 +1,11 @@
+// Package migrations embeds the SQL migrations so that the api binary can
+// apply them itself.
+package migrations
+
+import "embed"
+
+// Files holds every migration, named NNNNNN_description.up.sql and
+// NNNNNN_description.down.sql
+//
+//go:embed *.sql
+var Files embed.FS